@@ -0,0 +1,527 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+const (
+	attachmentsURL = "http://localhost:8080/api/v1/attachment"
+
+	// uploads which were interrupted, so that running message --attach again resumes them
+	uploadsFileName = "uploads.json"
+
+	// a chunk is sent this many times before the upload is left to be resumed later
+	chunkAttempts = 5
+)
+
+// pendingUpload is an upload the server has accepted but not completed yet
+// it is resumed only when the file has not changed since it was started
+type pendingUpload struct {
+	UploadID       uuid.UUID `json:"upload_id"`
+	Path           string    `json:"path"`
+	Size           int64     `json:"size"`
+	ModTime        time.Time `json:"mod_time"`
+	Checksum       string    `json:"checksum"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+}
+
+// this function reads the uploads waiting to be resumed, keyed by path and conversation
+func loadPendingUploads() map[string]pendingUpload {
+	uploads := make(map[string]pendingUpload)
+	if err := utility.ReadJSONFile(uploadsFileName, &uploads); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("error reading %s: %v", uploadsFileName, err)
+	}
+	return uploads
+}
+
+// this function records or, with a nil upload, forgets a pending upload
+func savePendingUpload(key string, upload *pendingUpload) {
+	uploads := loadPendingUploads()
+	if upload == nil {
+		delete(uploads, key)
+	} else {
+		uploads[key] = *upload
+	}
+	if err := utility.WriteJSONFile(uploadsFileName, uploads); err != nil {
+		log.Printf("error writing %s: %v", uploadsFileName, err)
+	}
+}
+
+// this function stores the refreshed access token returned with a response
+func storeAccessToken(accessToken string) {
+	if len(accessToken) == 0 {
+		return
+	}
+	if err := os.WriteFile(internal.TokenFile, []byte(accessToken), 0770); err != nil {
+		log.Printf("error updating auth file: %v", err)
+	}
+}
+
+// this function sends an authenticated request for the attachment api
+// body is sent as it is, contentType tells the server what it holds
+func attachmentRequest(verb string, url string, body []byte, contentType string) (*http.Response, error) {
+	authToken, err := os.ReadFile(internal.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading auth token: %w", err)
+	}
+
+	request, err := http.NewRequest(verb, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(contentType) > 0 {
+		request.Header.Set("Content-Type", contentType)
+	}
+	request.Header.Add("authorization", fmt.Sprintf("bearer %s", authToken))
+
+	httpClient := http.Client{Timeout: time.Minute}
+	return httpClient.Do(request)
+}
+
+// this function decodes the response of an upload request
+// a non nil error is returned for every status other than the expected one
+func decodeUploadResponse(response *http.Response, expected int) (*utility.UploadResponse, error) {
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case expected:
+		uploadResponse := utility.DecodeResponseBody(response.Body, &utility.UploadResponse{}).(*utility.UploadResponse)
+		storeAccessToken(uploadResponse.AccessToken)
+		return uploadResponse, nil
+	case http.StatusBadRequest, http.StatusNotAcceptable, http.StatusRequestEntityTooLarge, http.StatusConflict:
+		errorResponse := utility.DecodeResponseBody(response.Body, &utility.ErrorResponse{}).(*utility.ErrorResponse)
+		return nil, errors.New(errorResponse.Error)
+	default:
+		return nil, fmt.Errorf("server responded with %s", response.Status)
+	}
+}
+
+// this function returns the hex encoded sha256 of a file
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// this function detects the mime type of a file from its first bytes
+// the extension is used when the content alone only tells it is text or binary
+func detectMimeType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	read, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	detected := http.DetectContentType(header[:read])
+	if strings.HasPrefix(detected, "text/plain") || detected == "application/octet-stream" {
+		if byExtension := mime.TypeByExtension(filepath.Ext(path)); len(byExtension) > 0 {
+			return byExtension, nil
+		}
+	}
+	return detected, nil
+}
+
+// progress prints how much of a transfer is done on a single line of stderr
+type progress struct {
+	label   string
+	total   int64
+	printed time.Time
+}
+
+// this function prints the progress, at most a few times a second until it is done
+func (p *progress) update(done int64) {
+	if done < p.total && time.Since(p.printed) < 200*time.Millisecond {
+		return
+	}
+	p.printed = time.Now()
+
+	percent := int64(100)
+	if p.total > 0 {
+		percent = done * 100 / p.total
+	}
+	fmt.Fprintf(os.Stderr, "\r%s %3d%% (%s of %s)", p.label, percent, utility.FormatSize(done), utility.FormatSize(p.total))
+	if done >= p.total {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// this function uploads a file in chunks and returns the id of the attachment
+// an interrupted upload is resumed from where the server says it stopped
+func uploadAttachment(path string, conversationID uuid.UUID, isGroup bool, config internal.AttachmentsConfig) (uuid.UUID, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if info.IsDir() {
+		return uuid.Nil, fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() == 0 {
+		return uuid.Nil, fmt.Errorf("%s is empty", path)
+	}
+	if maxSize := config.MaxSizeMB * 1024 * 1024; info.Size() > maxSize {
+		return uuid.Nil, fmt.Errorf("%s is %s, attachments can be at most %s", path, utility.FormatSize(info.Size()), utility.FormatSize(maxSize))
+	}
+
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	key := fmt.Sprintf("%s|%s", conversationID.String(), absolutePath)
+
+	// resuming the previous upload of the same file when it has not changed
+	upload, resumed := loadPendingUploads()[key]
+	offset := int64(0)
+	if resumed && upload.Size == info.Size() && upload.ModTime.Equal(info.ModTime()) {
+		serverOffset, err := uploadOffset(upload.UploadID)
+		if errors.Is(err, errUploadStatus) {
+			resumed = false
+		} else if err != nil {
+			return uuid.Nil, err
+		} else {
+			if offset, err = checkUploadOffset(serverOffset, info.Size()); err != nil {
+				return uuid.Nil, err
+			}
+			log.Printf("resuming upload of %s at %s", filepath.Base(path), utility.FormatSize(offset))
+		}
+	} else {
+		resumed = false
+	}
+
+	if !resumed {
+		checksum, err := fileChecksum(path)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("error computing checksum of %s: %w", path, err)
+		}
+		mimeType, err := detectMimeType(path)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("error detecting type of %s: %w", path, err)
+		}
+
+		body := struct {
+			FileName   string `json:"file_name"`
+			Size       int64  `json:"size"`
+			MimeType   string `json:"mime_type"`
+			Checksum   string `json:"checksum"`
+			ReceiverID string `json:"receiver_id"`
+			GroupID    string `json:"group_id"`
+		}{
+			FileName: filepath.Base(path),
+			Size:     info.Size(),
+			MimeType: mimeType,
+			Checksum: checksum,
+		}
+		if isGroup {
+			body.GroupID = conversationID.String()
+		} else {
+			body.ReceiverID = conversationID.String()
+		}
+		requestBody, err := json.Marshal(body)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("error creating upload request body: %w", err)
+		}
+
+		response, err := attachmentRequest("POST", attachmentsURL+"/upload", requestBody, "application/json")
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("error starting upload: %w", err)
+		}
+		started, err := decodeUploadResponse(response, http.StatusCreated)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("error starting upload: %w", err)
+		}
+
+		upload = pendingUpload{
+			UploadID:       started.UploadID,
+			Path:           absolutePath,
+			Size:           info.Size(),
+			ModTime:        info.ModTime(),
+			Checksum:       checksum,
+			ConversationID: conversationID,
+		}
+		offset = started.Offset
+		savePendingUpload(key, &upload)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer file.Close()
+
+	chunk := make([]byte, config.ChunkSizeKB*1024)
+	bar := &progress{label: filepath.Base(path), total: info.Size()}
+	bar.update(offset)
+	for offset < info.Size() {
+		// the chunks already sent belong to the file as it was when the upload started
+		current, err := file.Stat()
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if current.Size() != info.Size() || !current.ModTime().Equal(info.ModTime()) {
+			fmt.Fprintln(os.Stderr)
+			return uuid.Nil, fmt.Errorf("%s changed while it was uploading, run the same command again to upload it from the start", path)
+		}
+
+		read, err := file.ReadAt(chunk, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return uuid.Nil, err
+		}
+		if read == 0 {
+			fmt.Fprintln(os.Stderr)
+			return uuid.Nil, fmt.Errorf("%s ended at %s before its size of %s", path, utility.FormatSize(offset), utility.FormatSize(info.Size()))
+		}
+
+		next, err := uploadChunk(upload.UploadID, chunk[:read], offset, info.Size())
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return uuid.Nil, fmt.Errorf("upload stopped at %s, run the same command again to resume: %w", utility.FormatSize(offset), err)
+		}
+		offset = next
+		bar.update(offset)
+	}
+
+	response, err := attachmentRequest("POST", fmt.Sprintf("%s/upload/%s/complete", attachmentsURL, upload.UploadID), nil, "")
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("error completing upload: %w", err)
+	}
+	completed, err := decodeUploadResponse(response, http.StatusCreated)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("error completing upload: %w", err)
+	}
+	savePendingUpload(key, nil)
+
+	return completed.AttachmentID, nil
+}
+
+// errUploadStatus is returned by uploadOffset when the server answered but does
+// not report the upload, as opposed to the server not being reachable
+var errUploadStatus = errors.New("server did not report the upload")
+
+// this function asks the server how much of an upload it has received
+func uploadOffset(uploadID uuid.UUID) (int64, error) {
+	response, err := attachmentRequest("GET", fmt.Sprintf("%s/upload/%s", attachmentsURL, uploadID), nil, "")
+	if err != nil {
+		return 0, fmt.Errorf("error checking upload status: %w", err)
+	}
+	status, err := decodeUploadResponse(response, http.StatusOK)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", errUploadStatus, err)
+	}
+
+	return status.Offset, nil
+}
+
+// this function sends a chunk of an upload starting at offset and returns the
+// offset the server expects next, failed attempts are repeated with a growing delay
+// when the server expects another offset, e.g. because an earlier attempt
+// reached it after all, the upload continues from the offset it reports
+func uploadChunk(uploadID uuid.UUID, chunk []byte, offset int64, total int64) (int64, error) {
+	url := fmt.Sprintf("%s/upload/%s?offset=%d", attachmentsURL, uploadID, offset)
+
+	var err error
+	delay := time.Second
+	for attempt := 1; attempt <= chunkAttempts; attempt++ {
+		var response *http.Response
+		response, err = attachmentRequest("PUT", url, chunk, "application/octet-stream")
+		if err == nil {
+			var status *utility.UploadResponse
+			status, err = decodeUploadResponse(response, http.StatusOK)
+			if err == nil {
+				return checkUploadOffset(status.Offset, total)
+			}
+			if response.StatusCode == http.StatusConflict {
+				next, statusErr := uploadOffset(uploadID)
+				if statusErr != nil {
+					return offset, statusErr
+				}
+				if next != offset {
+					return checkUploadOffset(next, total)
+				}
+				return offset, err
+			}
+			// the server rejected the chunk itself, sending it again will not help
+			if response.StatusCode < http.StatusInternalServerError {
+				return offset, err
+			}
+		}
+
+		if attempt < chunkAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	return offset, err
+}
+
+// this function checks that an offset reported by the server lies within the file
+func checkUploadOffset(offset int64, total int64) (int64, error) {
+	if offset < 0 || offset > total {
+		return offset, fmt.Errorf("server expects offset %d of a file of %d bytes", offset, total)
+	}
+	return offset, nil
+}
+
+// this function downloads an attachment into dir and verifies its checksum
+// a partly downloaded file is continued, the path of the saved file is returned
+func downloadAttachment(attachment *utility.Attachment, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return "", err
+	}
+
+	// names chosen by the sender must not point outside of dir
+	fileName := filepath.Base(filepath.Clean("/" + attachment.FileName))
+	if fileName == "/" || fileName == "." || fileName == `\` {
+		fileName = attachment.ID.String()
+	}
+	partPath := filepath.Join(dir, fileName+".part")
+
+	hasher := sha256.New()
+	offset, err := hashPartialFile(partPath, hasher)
+	if err != nil {
+		return "", err
+	}
+	// the whole file was downloaded by an earlier run which stopped before renaming it
+	if offset > 0 && offset >= attachment.Size {
+		return saveDownload(partPath, dir, fileName, hasher, attachment.Checksum)
+	}
+
+	authToken, err := os.ReadFile(internal.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading auth token: %w", err)
+	}
+	request, err := http.NewRequest("GET", fmt.Sprintf("%s/%s", attachmentsURL, attachment.ID), nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Add("authorization", fmt.Sprintf("bearer %s", authToken))
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	httpClient := http.Client{}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("error sending download request: %w", err)
+	}
+	defer response.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// the server sent the whole file again
+		flags |= os.O_TRUNC
+		offset = 0
+		hasher.Reset()
+	case http.StatusRequestedRangeNotSatisfiable:
+		// nothing is left after offset, the part file already holds the whole attachment
+		return saveDownload(partPath, dir, fileName, hasher, attachment.Checksum)
+	case http.StatusNotFound:
+		return "", errors.New("attachment no longer exists on server")
+	default:
+		return "", fmt.Errorf("server responded with %s", response.Status)
+	}
+
+	part, err := os.OpenFile(partPath, flags, 0660)
+	if err != nil {
+		return "", err
+	}
+	bar := &progress{label: fileName, total: attachment.Size}
+	written, err := io.Copy(io.MultiWriter(part, hasher, progressWriter{bar: bar, done: &offset}), response.Body)
+	closeErr := part.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		return "", fmt.Errorf("download stopped after %s, run the same command again to resume: %w", utility.FormatSize(written), err)
+	}
+	if closeErr != nil {
+		return "", closeErr
+	}
+
+	return saveDownload(partPath, dir, fileName, hasher, attachment.Checksum)
+}
+
+// this function verifies the checksum of a finished download and moves it to
+// its own name in dir, a file which does not match is removed
+func saveDownload(partPath string, dir string, fileName string, hasher hash.Hash, expected string) (string, error) {
+	if checksum := hex.EncodeToString(hasher.Sum(nil)); !strings.EqualFold(checksum, expected) {
+		os.Remove(partPath)
+		return "", fmt.Errorf("checksum of downloaded %s does not match, the file was removed", fileName)
+	}
+
+	savedPath := availablePath(filepath.Join(dir, fileName))
+	if err := os.Rename(partPath, savedPath); err != nil {
+		return "", err
+	}
+	return savedPath, nil
+}
+
+// this function feeds the part of a file downloaded earlier into hasher and returns its size
+func hashPartialFile(path string, hasher hash.Hash) (int64, error) {
+	part, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer part.Close()
+
+	return io.Copy(hasher, part)
+}
+
+// progressWriter updates a progress bar with the bytes written through it
+type progressWriter struct {
+	bar  *progress
+	done *int64
+}
+
+func (w progressWriter) Write(data []byte) (int, error) {
+	*w.done += int64(len(data))
+	w.bar.update(*w.done)
+	return len(data), nil
+}
+
+// this function returns path, or path with a number added when the file already exists
+func availablePath(path string) string {
+	extension := filepath.Ext(path)
+	base := strings.TrimSuffix(path, extension)
+	for number := 1; ; number++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+		path = fmt.Sprintf("%s (%d)%s", base, number, extension)
+	}
+}
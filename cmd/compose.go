@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+const (
+	// everything from this line on is left out of a message composed in the editor
+	scissorsLine = "# ------------------------ >8 ------------------------"
+
+	// the deamon forgets typing indicators which are not repeated within ten seconds
+	typingRefresh = 5 * time.Second
+
+	// value of --new when it is given without one, so that "--new --editor" parses
+	// as two flags and "--new hello" takes its text from the arguments,
+	// a blank message is never sent so it can not be mistaken for one
+	newTextFromArgs = " "
+)
+
+// this function checks a message before it is sent
+// blank lines around the message are removed, indentation is kept for code blocks
+func validateMessage(text string) (string, error) {
+	text = strings.TrimRight(text, " \t\r\n")
+	text = strings.TrimLeft(text, "\r\n")
+	if len(strings.TrimSpace(text)) == 0 {
+		return "", errors.New("message is empty, nothing was sent")
+	}
+	if length := utf8.RuneCountInString(text); length > internal.MaxMessageLength {
+		return "", fmt.Errorf("message is %d characters long but at most %d can be sent, send longer text as a file with --attach", length, internal.MaxMessageLength)
+	}
+
+	return text, nil
+}
+
+// this function reads a message piped into message --new -
+func readMessageFromStdin() (string, error) {
+	// reading a little more than the limit so that oversize messages are still reported
+	data, err := io.ReadAll(io.LimitReader(os.Stdin, internal.MaxMessageLength*utf8.UTFMax+1))
+	if err != nil {
+		return "", fmt.Errorf("error reading message from stdin: %w", err)
+	}
+	// checked before the encoding since the limit may have cut the last character in half,
+	// this many bytes hold more characters than can be sent
+	if len(data) > internal.MaxMessageLength*utf8.UTFMax {
+		return "", fmt.Errorf("message read from stdin is longer than %d characters, send longer text as a file with --attach", internal.MaxMessageLength)
+	}
+	if !utf8.Valid(data) {
+		return "", errors.New("message read from stdin is not valid utf-8 text")
+	}
+
+	return string(data), nil
+}
+
+// this function returns the editor to compose messages in from $VISUAL or $EDITOR
+// the value may carry arguments, e.g. "code --wait"
+func editorCommand() []string {
+	for _, variable := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.Fields(os.Getenv(variable)); len(editor) > 0 {
+			return editor
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// this function quotes a message as context below the scissors line of the editor
+func quoteContext(heading string, message utility.Message) []string {
+	lines := []string{heading}
+	for _, line := range strings.Split(strings.TrimRight(message.Description, "\r\n"), "\n") {
+		lines = append(lines, "# > "+strings.TrimRight(line, "\r"))
+	}
+	if message.Attachment != nil {
+		lines = append(lines, fmt.Sprintf("# > 📎 %s", message.Attachment.FileName))
+	}
+	return lines
+}
+
+// this function opens the editor on a temporary file holding initial text and
+// the context lines and returns what was written above the scissors line
+// while the editor is open the other side of the conversation sees user typing
+func composeInEditor(conversationID uuid.UUID, isGroup bool, initial string, context []string) (string, error) {
+	file, err := os.CreateTemp("", "terter-message-*.md")
+	if err != nil {
+		return "", fmt.Errorf("error creating message file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	template := []string{initial, "", scissorsLine, "# Write your message above this line, everything below it is left out.", "# Saving an empty message sends nothing."}
+	if len(context) > 0 {
+		template = append(template, "#")
+		template = append(template, context...)
+	}
+	if _, err = file.WriteString(strings.Join(template, "\n") + "\n"); err != nil {
+		file.Close()
+		return "", fmt.Errorf("error writing message file: %w", err)
+	}
+	if err = file.Close(); err != nil {
+		return "", fmt.Errorf("error writing message file: %w", err)
+	}
+
+	// repeating the typing indicator until the editor is closed
+	stopTyping := make(chan struct{})
+	typingStopped := make(chan struct{})
+	go func() {
+		defer close(typingStopped)
+		ticker := time.NewTicker(typingRefresh)
+		defer ticker.Stop()
+
+		sendTypingEvent(true, conversationID, isGroup)
+		for {
+			select {
+			case <-ticker.C:
+				sendTypingEvent(true, conversationID, isGroup)
+			case <-stopTyping:
+				sendTypingEvent(false, conversationID, isGroup)
+				return
+			}
+		}
+	}()
+
+	editor := editorCommand()
+	command := exec.Command(editor[0], append(editor[1:], file.Name())...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	err = command.Run()
+	close(stopTyping)
+	<-typingStopped
+	if err != nil {
+		return "", fmt.Errorf("error running editor %s: %w", editor[0], err)
+	}
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", fmt.Errorf("error reading message file: %w", err)
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if index := strings.Index(text, scissorsLine); index >= 0 {
+		text = text[:index]
+	}
+
+	return text, nil
+}
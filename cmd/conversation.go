@@ -5,16 +5,19 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal"
 	"github.com/harshvardha/TerTerChatCLI/utility"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -65,6 +68,450 @@ func getMessagesMap(fileName string) map[int]utility.Message {
 	return messagesMap
 }
 
+// this function finds the conversation shown at index in 'conversation --list'
+// and returns the receiver id or group id with the name of conversation
+func resolveConversation(index int) (uuid.UUID, string, bool, error) {
+	if index < 1 {
+		return uuid.Nil, "", false, fmt.Errorf("invalid conversation index %d", index)
+	}
+
+	oneToOneConversationsMap := getOneToOneConversationMap()
+	if index-1 < len(oneToOneConversationsMap) {
+		conversation, ok := oneToOneConversationsMap[index-1]
+		if ok {
+			return conversation.ReceiverID, conversation.Username, false, nil
+		}
+	}
+
+	// group.json is written by 'conversation --list' with the groups keyed by the
+	// index shown to the user, so they continue after the one to one conversations
+	groupConversationsMap := make(map[int]utility.GroupConversation)
+	if err := utility.ReadJSONFile("group.json", &groupConversationsMap); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return uuid.Nil, "", false, fmt.Errorf("error reading group conversations: %w", err)
+	}
+	if group, ok := groupConversationsMap[index-1]; ok && group.GroupID.Valid {
+		return group.GroupID.UUID, group.GroupName, true, nil
+	}
+
+	return uuid.Nil, "", false, fmt.Errorf("no conversation at index %d, run 'TerTer conversation --list' first", index)
+}
+
+// this function builds the indicator shown after messages sent by user
+// ✓ sent, ✓✓ delivered and ✓✓ read once the receiver or group members read it
+func messageStatus(message utility.Message, receipt internal.MessageReceipt) string {
+	switch {
+	case message.GroupID.Valid && len(receipt.Readers) > 0:
+		return fmt.Sprintf("✓✓ read by %d", len(receipt.Readers))
+	case message.Read:
+		return "✓✓ read"
+	case message.Recieved || receipt.Delivered():
+		return "✓✓"
+	case message.Sent:
+		return "✓"
+	default:
+		return "pending"
+	}
+}
+
+// longest part of a parent message quoted above its replies
+const quoteLength = 60
+
+// this function shortens a message to a single line quote
+func quoteSnippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) > quoteLength {
+		return string(runes[:quoteLength]) + "…"
+	}
+	return text
+}
+
+// this function indexes messages by id so that replies can find their parent
+func messagesByID(messages []utility.Message) map[uuid.UUID]utility.Message {
+	byID := make(map[uuid.UUID]utility.Message, len(messages))
+	for _, message := range messages {
+		byID[message.ID] = message
+	}
+	return byID
+}
+
+// this function prints a message of an opened conversation along with its index
+// replies are preceded by a quote of their parent and messages sent by user
+// are followed by their delivery status
+func printMessage(index int, message utility.Message, mine bool, receipt internal.MessageReceipt, byID map[uuid.UUID]utility.Message, reactions []internal.MessageReaction) {
+	if message.ParentID.Valid {
+		if parent, ok := byID[message.ParentID.UUID]; ok {
+			fmt.Printf("   > %s\n", quoteSnippet(parent.Description))
+		} else {
+			fmt.Println("   > (earlier message)")
+		}
+	}
+	if mine {
+		fmt.Printf("%d. You: %s, %s %s\n", index+1, message.Description, message.CreatedAt.Format(time.RFC1123), messageStatus(message, receipt))
+	} else {
+		fmt.Printf("%d. %s, %s\n", index+1, message.Description, message.CreatedAt.Format(time.RFC1123))
+	}
+	if message.Attachment != nil {
+		fmt.Printf("   📎 %s (%s, %s)\n", message.Attachment.FileName, utility.FormatSize(message.Attachment.Size), message.Attachment.MimeType)
+	}
+	if len(reactions) > 0 {
+		fmt.Printf("   %s\n", reactionCounts(reactions))
+	}
+}
+
+// this function counts the reactions to a message by emoji, e.g. "👍 2  ❤️ 1"
+// emojis are ordered by count and then by which was used first
+func reactionCounts(reactions []internal.MessageReaction) string {
+	counts := make(map[string]int)
+	first := make(map[string]time.Time)
+	emojis := make([]string, 0)
+	for _, reaction := range reactions {
+		if counts[reaction.Emoji] == 0 {
+			emojis = append(emojis, reaction.Emoji)
+		}
+		counts[reaction.Emoji]++
+		if at, ok := first[reaction.Emoji]; !ok || reaction.ReactedAt.Before(at) {
+			first[reaction.Emoji] = reaction.ReactedAt
+		}
+	}
+	slices.SortStableFunc(emojis, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return first[a].Compare(first[b])
+	})
+
+	parts := make([]string, 0, len(emojis))
+	for _, emoji := range emojis {
+		parts = append(parts, fmt.Sprintf("%s %d", emoji, counts[emoji]))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// this function lists who reacted to a message with which emoji for message --reactions
+func printReactions(message utility.Message, reactions []internal.MessageReaction) {
+	fmt.Printf("Message: %s\n", message.Description)
+	if len(reactions) == 0 {
+		fmt.Println("No reactions yet")
+		return
+	}
+
+	fmt.Printf("Reactions: %s\n", reactionCounts(reactions))
+	reactions = slices.Clone(reactions)
+	slices.SortFunc(reactions, func(a, b internal.MessageReaction) int { return a.ReactedAt.Compare(b.ReactedAt) })
+	for _, reaction := range reactions {
+		fmt.Printf("  %s %s, %s\n", reaction.Emoji, reaction.Username, reaction.ReactedAt.Local().Format(time.RFC1123))
+	}
+}
+
+// this function reacts to a message or takes a reaction back through the deamon
+// which holds the connection to the server
+func sendReactionRequest(request internal.ReactionRequest) error {
+	if !isDeamonRunning() {
+		return errors.New("deamon is not running, start it with 'TerTer daemon start'")
+	}
+
+	requestJson, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error marshalling reaction request: %w", err)
+	}
+	response, err := sendDeamonCommand("react " + string(requestJson))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(response)) != "ok" {
+		return errors.New(strings.TrimPrefix(strings.TrimSpace(string(response)), "error: "))
+	}
+	return nil
+}
+
+// this function prints the delivery details of a message for message --info
+// for group messages it lists who has read it and which cached members have not
+func printMessageInfo(message utility.Message, conversationName string, receipt internal.MessageReceipt) {
+	fmt.Printf("Message:      %s\n", message.Description)
+	fmt.Printf("Conversation: %s\n", conversationName)
+	fmt.Printf("Sent:         %s\n", message.CreatedAt.Format(time.RFC1123))
+	if !receipt.DeliveredAt.IsZero() {
+		fmt.Printf("Delivered:    %s\n", receipt.DeliveredAt.Local().Format(time.RFC1123))
+	}
+	fmt.Printf("Status:       %s\n", messageStatus(message, receipt))
+	if !message.GroupID.Valid {
+		return
+	}
+
+	readers := slices.Clone(receipt.Readers)
+	slices.SortFunc(readers, func(a, b internal.MessageReader) int { return a.ReadAt.Compare(b.ReadAt) })
+	fmt.Println("Read by:")
+	if len(readers) == 0 {
+		fmt.Println("  nobody yet")
+	}
+	read := make(map[uuid.UUID]bool)
+	for _, reader := range readers {
+		read[reader.ID] = true
+		fmt.Printf("  %s, %s\n", reader.Username, reader.ReadAt.Local().Format(time.RFC1123))
+	}
+
+	// members are only known once group --members has cached them
+	members := make(map[int]utility.GroupMember)
+	if err := utility.ReadJSONFile(fmt.Sprintf("%s_members.json", message.GroupID.UUID.String()), &members); err != nil {
+		return
+	}
+	unread := make([]string, 0)
+	for _, member := range members {
+		if !read[member.ID] && member.ID != message.SenderID {
+			unread = append(unread, member.Username)
+		}
+	}
+	if len(unread) > 0 {
+		slices.Sort(unread)
+		fmt.Println("Not read yet:")
+		for _, username := range unread {
+			fmt.Printf("  %s\n", username)
+		}
+	}
+}
+
+// this function asks the deamon to send read receipts for the displayed messages
+// which were sent by others, nothing is sent when the deamon is not running
+func markMessagesRead(groupID uuid.UUID, messages []utility.Message, self uuid.UUID) {
+	request := internal.ReadRequest{GroupID: groupID}
+	for _, message := range messages {
+		if message.SenderID == self || (groupID == uuid.Nil && message.Read) {
+			continue
+		}
+		request.Messages = append(request.Messages, internal.ReadMessage{ID: message.ID, SenderID: message.SenderID})
+	}
+	if len(request.Messages) == 0 || !isDeamonRunning() {
+		return
+	}
+
+	requestJson, err := json.Marshal(request)
+	if err != nil {
+		log.Printf("error marshalling read request: %v", err)
+		return
+	}
+	response, err := sendDeamonCommand("read " + string(requestJson))
+	if err != nil {
+		log.Printf("error sending read receipts: %v", err)
+		return
+	}
+	if !strings.HasPrefix(string(response), "marked") && !strings.HasPrefix(string(response), "read receipts are turned off") {
+		log.Print(strings.TrimSpace(string(response)))
+	}
+}
+
+// this function changes the unread counts through the deamon so that its
+// updates are not lost, the file is changed directly when the deamon is not running
+func updateUnread(argument string, fallback func() error) error {
+	if !isDeamonRunning() {
+		return fallback()
+	}
+
+	response, err := sendDeamonCommand("unread " + argument)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(response)) != "ok" {
+		return errors.New(strings.TrimPrefix(strings.TrimSpace(string(response)), "error: "))
+	}
+	return nil
+}
+
+// this function clears the unread count of a conversation, or all with internal.MarkAllRead
+func markConversationRead(key string) error {
+	return updateUnread("clear "+key, func() error { return internal.MarkRead(key) })
+}
+
+// this function formats the unread count shown after a conversation in --list
+func unreadSuffix(counts map[string]internal.UnreadConversation, conversationID uuid.UUID) string {
+	if count := counts[conversationID.String()].Count; count > 0 {
+		return fmt.Sprintf(" (%d unread)", count)
+	}
+	return ""
+}
+
+// this function asks the deamon for the presence of users it has seen
+// nothing is known when the deamon is not running
+func queryPresence() []internal.PresenceEntry {
+	if !isDeamonRunning() {
+		return nil
+	}
+	response, err := sendDeamonCommand("presence")
+	if err != nil {
+		log.Printf("error querying presence: %v", err)
+		return nil
+	}
+
+	entries := make([]internal.PresenceEntry, 0)
+	if err = json.Unmarshal(response, &entries); err != nil {
+		log.Printf("error unmarshalling presence: %v", err)
+		return nil
+	}
+	return entries
+}
+
+// this function prints who is typing in a conversation and, for one to one
+// conversations, when the other user was last seen
+func printPresence(conversationID uuid.UUID, isGroup bool) {
+	now := time.Now()
+	typing := make([]string, 0)
+	for _, entry := range queryPresence() {
+		if entry.IsTypingIn(conversationID, now) {
+			typing = append(typing, entry.Username)
+			continue
+		}
+		if isGroup || entry.UserID != conversationID {
+			continue
+		}
+		if entry.Online {
+			fmt.Printf("%s is online\n", entry.Username)
+		} else if !entry.LastSeen.IsZero() {
+			fmt.Printf("%s was last seen %s\n", entry.Username, entry.LastSeen.Local().Format(time.RFC1123))
+		}
+	}
+
+	switch len(typing) {
+	case 0:
+	case 1:
+		fmt.Printf("%s is typing…\n", typing[0])
+	case 2:
+		fmt.Printf("%s and %s are typing…\n", typing[0], typing[1])
+	default:
+		fmt.Printf("%d people are typing…\n", len(typing))
+	}
+}
+
+// this function tells the other side of a conversation that user started or stopped typing
+func sendTypingEvent(start bool, conversationID uuid.UUID, isGroup bool) {
+	if !isDeamonRunning() {
+		return
+	}
+
+	request := internal.TypingRequest{ReceiverID: conversationID}
+	if isGroup {
+		request = internal.TypingRequest{GroupID: conversationID}
+	}
+	requestJson, err := json.Marshal(request)
+	if err != nil {
+		log.Printf("error marshalling typing request: %v", err)
+		return
+	}
+
+	action := "stop"
+	if start {
+		action = "start"
+	}
+	if _, err = sendDeamonCommand(fmt.Sprintf("typing %s %s", action, requestJson)); err != nil {
+		log.Printf("error sending typing event: %v", err)
+	}
+}
+
+// this function sends a new message to the conversation at index
+// parentID is set when the message is a reply to an earlier message and
+// attachmentID when a file uploaded with message --attach is sent along
+func sendNewMessage(index int, description string, parentID uuid.UUID, attachmentID uuid.UUID) error {
+	conversationID, _, isGroup, err := resolveConversation(index)
+	if err != nil {
+		return err
+	}
+	authToken, err := os.ReadFile(internal.TokenFile)
+	if err != nil {
+		return fmt.Errorf("error reading auth token: %w", err)
+	}
+
+	body := struct {
+		Description  string `json:"description"`
+		ReceiverID   string `json:"receiver_id"`
+		GroupID      string `json:"group_id"`
+		ParentID     string `json:"parent_id,omitempty"`
+		AttachmentID string `json:"attachment_id,omitempty"`
+	}{
+		Description: description,
+	}
+	if isGroup {
+		body.GroupID = conversationID.String()
+	} else {
+		body.ReceiverID = conversationID.String()
+	}
+	if parentID != uuid.Nil {
+		body.ParentID = parentID.String()
+	}
+	if attachmentID != uuid.Nil {
+		body.AttachmentID = attachmentID.String()
+	}
+	requestBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error creating request body for new message request: %w", err)
+	}
+
+	request, err := CreateRequest("POST", "http://localhost:8080/api/v1/message/create", requestBody)
+	if err != nil {
+		return fmt.Errorf("error creating new message request: %w", err)
+	}
+	request.Header.Add("authorization", fmt.Sprintf("bearer %s", authToken))
+
+	httpClient := http.Client{}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error sending new message request: %w", err)
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusCreated:
+		updateAuthFileForEmptyResponse(response.Body)
+		return nil
+	case http.StatusInternalServerError:
+		return errors.New("server error")
+	case http.StatusBadRequest, http.StatusNotAcceptable:
+		errorResponse := utility.DecodeResponseBody(response.Body, &utility.ErrorResponse{}).(*utility.ErrorResponse)
+		return errors.New(errorResponse.Error)
+	default:
+		return errors.New("invalid response")
+	}
+}
+
+// this function prints a message and all replies to it from the cached
+// messages of a conversation, replies are indented below their parent
+func printThread(messagesMap map[int]utility.Message, messageIndex int) error {
+	message, ok := messagesMap[messageIndex-1]
+	if !ok {
+		return errors.New("invalid message index")
+	}
+
+	indexOf := make(map[uuid.UUID]int, len(messagesMap))
+	children := make(map[uuid.UUID][]utility.Message)
+	for index, cached := range messagesMap {
+		indexOf[cached.ID] = index
+		if cached.ParentID.Valid {
+			children[cached.ParentID.UUID] = append(children[cached.ParentID.UUID], cached)
+		}
+	}
+
+	// starting from the first message of the thread which is loaded
+	root := message
+	for root.ParentID.Valid {
+		parentIndex, ok := indexOf[root.ParentID.UUID]
+		if !ok {
+			break
+		}
+		root = messagesMap[parentIndex]
+	}
+
+	var printReplies func(message utility.Message, depth int)
+	printReplies = func(message utility.Message, depth int) {
+		fmt.Printf("%s%d. %s, %s\n", strings.Repeat("    ", depth), indexOf[message.ID]+1, message.Description, message.CreatedAt.Format(time.RFC1123))
+		replies := children[message.ID]
+		slices.SortFunc(replies, func(a, b utility.Message) int { return a.CreatedAt.Compare(b.CreatedAt) })
+		for _, reply := range replies {
+			printReplies(reply, depth+1)
+		}
+	}
+	printReplies(root, 0)
+
+	return nil
+}
+
 var conversationIndex int
 
 // conversationCmd represents the conversation command
@@ -111,6 +558,12 @@ var conversationCmd = &cobra.Command{
 								log.Printf("error updating auth token: %v", err)
 							}
 
+							// unread counts are kept by the deamon from new message events
+							unreadCounts, err := internal.LoadUnread()
+							if err != nil {
+								log.Print(err)
+							}
+
 							var offset uint // offset will track the converstaion number which can be used as index by user to do other operations
 
 							// checking of one to one conversations file which stores receivers id exist or not
@@ -134,7 +587,7 @@ var conversationCmd = &cobra.Command{
 							for _, value := range conversations.OneToOneConversations {
 								// printing the name of the receiver with index
 								// index is the key of the receiver id in one_to_one conversation json file
-								fmt.Printf("%d - %s", offset+1, value.Username)
+								fmt.Printf("%d - %s%s\n", offset+1, value.Username, unreadSuffix(unreadCounts, value.ReceiverID))
 
 								// writing the conversation to the map
 								oneToOneConversations[offset] = value
@@ -158,7 +611,7 @@ var conversationCmd = &cobra.Command{
 							for _, value := range conversations.GroupConversations {
 								// printing the name of group with index
 								// index is the position of the group id in group conversation json file
-								fmt.Printf("%d - %s", offset+1, value.GroupName)
+								fmt.Printf("%d - %s%s\n", offset+1, value.GroupName, unreadSuffix(unreadCounts, value.GroupID.UUID))
 
 								// writing the group conversation to the map
 								groupConversationMap[offset] = value
@@ -180,6 +633,46 @@ var conversationCmd = &cobra.Command{
 					}
 				}
 				response.Body.Close()
+			case "mark-read":
+				// clearing the unread count of a conversation without opening it
+				index, err := strconv.Atoi(f.Value.String())
+				if err != nil {
+					log.Printf("error reading index: %v", err)
+					return
+				}
+				conversationID, conversationName, _, err := resolveConversation(index)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				if err = markConversationRead(conversationID.String()); err != nil {
+					log.Printf("error marking conversation read: %v", err)
+					return
+				}
+				fmt.Printf("Marked %s as read\n", conversationName)
+			case "mark-all-read":
+				if err = markConversationRead(internal.MarkAllRead); err != nil {
+					log.Printf("error marking conversations read: %v", err)
+					return
+				}
+				fmt.Println("Marked all conversations as read")
+			case "unread":
+				// total number of unread messages, answered by the deamon when it is running
+				if isDeamonRunning() {
+					response, err := sendDeamonCommand("unread")
+					if err != nil {
+						log.Printf("error querying unread count: %v", err)
+						return
+					}
+					fmt.Print(string(response))
+					return
+				}
+				unreadCounts, err := internal.LoadUnread()
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				fmt.Println(internal.UnreadTotal(unreadCounts))
 			case "open":
 				// user will provide the index of the conversation they want to open
 				// first we will find out whether that index exist in one_to_one conversation or group conversation
@@ -243,16 +736,40 @@ var conversationCmd = &cobra.Command{
 								}
 							}
 
+							// receipts recorded by the deamon fill in deliveries the server has not reported yet
+							receiptsMap, err := internal.LoadReceipts()
+							if err != nil {
+								log.Print(err)
+							}
+							reactionsMap, err := internal.LoadReactions()
+							if err != nil {
+								log.Print(err)
+							}
+
+							byID := messagesByID(messages.Messages)
 							messagesMap := make(map[int]utility.Message)
 							for index, message := range messages.Messages {
+								receipt := receiptsMap[message.ID]
+								if receipt.Delivered() {
+									message.Recieved = true
+								}
 								messagesMap[index] = message
 								if message.SenderID == receiverId {
-									fmt.Printf("%s, %s", message.Description, message.CreatedAt.Format(time.RFC1123))
+									printMessage(index, message, false, receipt, byID, reactionsMap[message.ID])
 								} else if message.RecieverID.UUID == receiverId {
-									fmt.Printf("You: %s, %s", message.Description, message.CreatedAt.Format(time.RFC1123))
+									printMessage(index, message, true, receipt, byID, reactionsMap[message.ID])
 								}
 							}
 
+							printPresence(receiverId, false)
+
+							// telling the other user which of their messages we have now seen
+							self, _ := utility.ParseTokenClaims(string(authToken))
+							markMessagesRead(uuid.Nil, messages.Messages, self.UserID)
+							if err = markConversationRead(receiverId.String()); err != nil {
+								log.Printf("error clearing unread count: %v", err)
+							}
+
 							// writing messages map to messages json file
 							jsonData, err := json.MarshalIndent(messagesMap, "", " ")
 							if err != nil {
@@ -322,10 +839,27 @@ var conversationCmd = &cobra.Command{
 								}
 							}
 
+							// read receipts of group members are recorded by the deamon
+							receiptsMap, err := internal.LoadReceipts()
+							if err != nil {
+								log.Print(err)
+							}
+							reactionsMap, err := internal.LoadReactions()
+							if err != nil {
+								log.Print(err)
+							}
+							self, _ := utility.ParseTokenClaims(string(authToken))
+
+							byID := messagesByID(messages.Messages)
 							groupChatsMap := make(map[int]utility.Message)
 							for index, message := range messages.Messages {
 								groupChatsMap[index] = message
-								fmt.Printf("%s, %s", message.Description, message.CreatedAt.Format(time.RFC1123))
+								printMessage(index, message, message.SenderID == self.UserID, receiptsMap[message.ID], byID, reactionsMap[message.ID])
+							}
+							printPresence(groupConversationsMap[index-1].GroupID.UUID, true)
+							markMessagesRead(groupConversationsMap[index-1].GroupID.UUID, messages.Messages, self.UserID)
+							if err = markConversationRead(groupConversationsMap[index-1].GroupID.UUID.String()); err != nil {
+								log.Printf("error clearing unread count: %v", err)
 							}
 
 							// writing the group chats map into a json file
@@ -433,6 +967,11 @@ var messageCmd = &cobra.Command{
 	Long: `The 'message' command allows you to interact with a specific
 			message using its unique numerical index.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkScheduleFlags(cmd); err != nil {
+			log.Print(err)
+			return
+		}
+
 		cmd.Flags().Visit(func(f *pflag.Flag) {
 			// process the flags for message command
 			flag := f.Name
@@ -454,116 +993,293 @@ var messageCmd = &cobra.Command{
 			}
 
 			switch strings.ToLower(flag) {
-			case "new":
-				// checking if the conversation index is in one to one conversation json file
-				oneToOneConversationsMap := getOneToOneConversationMap()
-
-				if conversationIndex-1 < len(oneToOneConversationsMap) {
-					// creating new message request
-					message := f.Value.String()
-					requestBody, err := json.Marshal(struct {
-						Description string `json:"description"`
-						ReceiverID  string `json:"receiver_id"`
-						GroupID     string `json:"group_id"`
-					}{
-						Description: message,
-						ReceiverID:  oneToOneConversationsMap[conversationIndex-1].ReceiverID.String(),
-						GroupID:     "",
-					})
-					if err != nil {
-						log.Printf("error creating request body for new message request: %v", err)
+			case "reply":
+				// replying to a message of the conversation with the text given as argument
+				messageIndex, err := strconv.Atoi(f.Value.String())
+				if err != nil {
+					log.Printf("error converting message index from string to integer: %v", err)
+					return
+				}
+				editor, _ := cmd.Flags().GetBool("editor")
+				if len(args) == 0 && !editor {
+					log.Print("missing reply text")
+					return
+				}
+				conversationID, _, isGroup, err := resolveConversation(conversationIndex)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				parent, ok := getMessagesMap(conversationID.String())[messageIndex-1]
+				if !ok {
+					log.Print("invalid message index")
+					return
+				}
+				sendAt, scheduled, err := scheduledTime(cmd)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				text := strings.Join(args, " ")
+				if editor {
+					// the arguments, when given, are the start of the reply
+					context := quoteContext(fmt.Sprintf("# Replying to message %d:", messageIndex), parent)
+					if text, err = composeInEditor(conversationID, isGroup, text, context); err != nil {
+						log.Print(err)
 						return
 					}
-
-					// creating request
-					newMessageRequest, err := CreateRequest("POST", "http://localhost:8080/api/v1/message/create", requestBody)
+				}
+				if text, err = validateMessage(text); err != nil {
+					log.Print(err)
+					return
+				}
+				if scheduled {
+					id, err := scheduleMessage(conversationIndex, text, parent.ID, sendAt)
 					if err != nil {
-						log.Printf("error creating new message request please try again: %v", err)
+						log.Print(err)
 						return
 					}
+					log.Printf("reply %d scheduled for %s", id, sendAt.Format(commandTimeLayout))
+					return
+				}
+				if err = sendNewMessage(conversationIndex, text, parent.ID, uuid.Nil); err != nil {
+					log.Print(err)
+					return
+				}
+				log.Print("reply sent!")
+			case "thread":
+				// showing a message with every reply to it
+				messageIndex, err := strconv.Atoi(f.Value.String())
+				if err != nil {
+					log.Printf("error converting message index from string to integer: %v", err)
+					return
+				}
+				conversationID, _, _, err := resolveConversation(conversationIndex)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				if err = printThread(getMessagesMap(conversationID.String()), messageIndex); err != nil {
+					log.Print(err)
+				}
+			case "attach":
+				// uploading a file and sending it with the text given as argument as caption
+				if _, scheduled, _ := scheduledTime(cmd); scheduled {
+					log.Print("attachments can not be scheduled")
+					return
+				}
+				conversationID, _, isGroup, err := resolveConversation(conversationIndex)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				config, err := internal.LoadConfig()
+				if err != nil {
+					log.Printf("error loading config: %v", err)
+					return
+				}
+				attachmentID, err := uploadAttachment(f.Value.String(), conversationID, isGroup, config.Attachments)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				if err = sendNewMessage(conversationIndex, strings.Join(args, " "), uuid.Nil, attachmentID); err != nil {
+					log.Printf("file was uploaded but the message could not be sent: %v", err)
+					return
+				}
+				log.Print("attachment sent!")
+			case "download":
+				// saving the file attached to a message into the directory given as argument
+				messageIndex, err := strconv.Atoi(f.Value.String())
+				if err != nil {
+					log.Printf("error converting message index from string to integer: %v", err)
+					return
+				}
+				conversationID, _, _, err := resolveConversation(conversationIndex)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				message, ok := getMessagesMap(conversationID.String())[messageIndex-1]
+				if !ok {
+					log.Print("invalid message index")
+					return
+				}
+				if message.Attachment == nil {
+					log.Print("message has no attachment")
+					return
+				}
+				dir := "."
+				if len(args) > 0 {
+					dir = args[0]
+				}
+				savedPath, err := downloadAttachment(message.Attachment, dir)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				log.Printf("saved %s", savedPath)
+			case "react", "unreact":
+				// reacting to a message of the conversation with the emoji given as argument
+				// --unreact without an emoji takes back every reaction of user to the message
+				messageIndex, err := strconv.Atoi(f.Value.String())
+				if err != nil {
+					log.Printf("error converting message index from string to integer: %v", err)
+					return
+				}
+				remove := strings.ToLower(flag) == "unreact"
+				if len(args) == 0 && !remove {
+					log.Print("missing emoji to react with")
+					return
+				}
+				conversationID, _, isGroup, err := resolveConversation(conversationIndex)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				message, ok := getMessagesMap(conversationID.String())[messageIndex-1]
+				if !ok {
+					log.Print("invalid message index")
+					return
+				}
 
-					// sending request
-					newMessageRequest.Header.Add("authorization", fmt.Sprintf("bearer %s", authToken))
-					response, err := httpClient.Do(newMessageRequest)
+				request := internal.ReactionRequest{MessageID: message.ID, ReceiverID: conversationID, Remove: remove}
+				if isGroup {
+					request = internal.ReactionRequest{MessageID: message.ID, GroupID: conversationID, Remove: remove}
+				}
+				emojis := args
+				if len(emojis) == 0 {
+					reactionsMap, err := internal.LoadReactions()
 					if err != nil {
-						log.Printf("error sending request please try again: %v", err)
+						log.Print(err)
 						return
 					}
-
-					// parsing response
-					switch response.StatusCode {
-					case http.StatusCreated:
-						log.Printf("message sent!")
-						updateAuthFileForEmptyResponse(response.Body)
-						return
-					case http.StatusInternalServerError:
-						log.Printf("server error")
-					case http.StatusBadRequest:
-						fallthrough
-					case http.StatusNotAcceptable:
-						errorResponse := utility.DecodeResponseBody(response.Body, &utility.ErrorResponse{}).(*utility.ErrorResponse)
-						if errorResponse != nil {
-							log.Print(errorResponse.Error)
+					self, _ := utility.ParseTokenClaims(string(authToken))
+					for _, reaction := range reactionsMap[message.ID] {
+						if reaction.UserID == self.UserID {
+							emojis = append(emojis, reaction.Emoji)
 						}
 					}
+					if len(emojis) == 0 {
+						log.Print("you have not reacted to this message")
+						return
+					}
 				}
-
-				// checking if conversation index is in group conversations json file
-				groupConversationsMap := getGroupsMapFromJsonFile()
-
-				if conversationIndex-1 < len(groupConversationsMap) {
-					// creating new group message
-					message := f.Value.String()
-
-					// creating request body
-					requestBody, err := json.Marshal(struct {
-						Description string `json:"description"`
-						ReceiverID  string `json:"receiver_id"`
-						GroupID     string `json:"group_id"`
-					}{
-						Description: message,
-						ReceiverID:  "",
-						GroupID:     groupConversationsMap[conversationIndex-1].GroupID.UUID.String(),
-					})
-					if err != nil {
-						log.Printf("error creating new group message request body: %v", err)
+				for _, emoji := range emojis {
+					request.Emoji = emoji
+					if err = sendReactionRequest(request); err != nil {
+						log.Print(err)
 						return
 					}
-
-					// creating request
-					request, err := CreateRequest("POST", "http://localhost:8080/api/v1/message/create", requestBody)
-					if err != nil {
-						log.Printf("error creating request: %v", err)
+				}
+				if remove {
+					log.Print("reaction removed!")
+				} else {
+					log.Print("reaction sent!")
+				}
+			case "reactions":
+				// listing who reacted to a message of the conversation
+				messageIndex, err := strconv.Atoi(f.Value.String())
+				if err != nil {
+					log.Printf("error converting message index from string to integer: %v", err)
+					return
+				}
+				conversationID, _, _, err := resolveConversation(conversationIndex)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				message, ok := getMessagesMap(conversationID.String())[messageIndex-1]
+				if !ok {
+					log.Print("invalid message index")
+					return
+				}
+				reactionsMap, err := internal.LoadReactions()
+				if err != nil {
+					log.Print(err)
+				}
+				printReactions(message, reactionsMap[message.ID])
+			case "info":
+				// showing the delivery and read status of a message of the conversation
+				messageIndex, err := strconv.Atoi(f.Value.String())
+				if err != nil {
+					log.Printf("error converting message index from string to integer: %v", err)
+					return
+				}
+				conversationID, conversationName, _, err := resolveConversation(conversationIndex)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				message, ok := getMessagesMap(conversationID.String())[messageIndex-1]
+				if !ok {
+					log.Print("invalid message index")
+					return
+				}
+				receiptsMap, err := internal.LoadReceipts()
+				if err != nil {
+					log.Print(err)
+				}
+				printMessageInfo(message, conversationName, receiptsMap[message.ID])
+			case "new", "editor":
+				// the text is given as value or as arguments, read from stdin with "-" or written in the editor
+				editor, _ := cmd.Flags().GetBool("editor")
+				text := f.Value.String()
+				if strings.ToLower(flag) == "editor" {
+					if !editor || cmd.Flags().Changed("new") || cmd.Flags().Changed("reply") || cmd.Flags().Changed("edit") {
 						return
 					}
+					text = strings.Join(args, " ")
+				} else if text == newTextFromArgs {
+					text = strings.Join(args, " ")
+				}
 
-					// sending request
-					request.Header.Add("authorization", fmt.Sprintf("bearer %s", authToken))
-					response, err := httpClient.Do(request)
-					if err != nil {
-						log.Printf("error sending request: %v", err)
+				conversationID, conversationName, isGroup, err := resolveConversation(conversationIndex)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				sendAt, scheduled, err := scheduledTime(cmd)
+				if err != nil {
+					log.Print(err)
+					return
+				}
+				switch {
+				case text == "-":
+					if text, err = readMessageFromStdin(); err != nil {
+						log.Print(err)
 						return
 					}
-
-					// parsing response
-					switch response.StatusCode {
-					case http.StatusCreated:
-						log.Print("message sent!")
-						updateAuthFileForEmptyResponse(response.Body)
+				case editor:
+					// the latest message of the conversation is quoted for context
+					var context []string
+					messagesMap := getMessagesMap(conversationID.String())
+					if latest, ok := messagesMap[len(messagesMap)-1]; ok {
+						context = quoteContext(fmt.Sprintf("# Latest message in %s:", conversationName), latest)
+					}
+					if text, err = composeInEditor(conversationID, isGroup, text, context); err != nil {
+						log.Print(err)
 						return
-					case http.StatusInternalServerError:
-						log.Printf("server error")
-					case http.StatusBadRequest:
-						fallthrough
-					case http.StatusNotAcceptable:
-						errorResponse := utility.DecodeResponseBody(response.Body, &utility.ErrorResponse{}).(*utility.ErrorResponse)
-						if errorResponse != nil {
-							log.Print(errorResponse.Error)
-						}
-					default:
-						log.Print("invalid response")
 					}
 				}
+				if text, err = validateMessage(text); err != nil {
+					log.Print(err)
+					return
+				}
+				if scheduled {
+					id, err := scheduleMessage(conversationIndex, text, uuid.Nil, sendAt)
+					if err != nil {
+						log.Print(err)
+						return
+					}
+					log.Printf("message %d scheduled for %s", id, sendAt.Format(commandTimeLayout))
+					return
+				}
+				if err = sendNewMessage(conversationIndex, text, uuid.Nil, uuid.Nil); err != nil {
+					log.Print(err)
+					return
+				}
+				log.Print("message sent!")
 			case "edit":
 				// getting message index to edit
 				messageIndexString := f.Value.String()
@@ -848,10 +1564,25 @@ func init() {
 	conversationCmd.Flags().Bool("list", false, "provides list of all the conversation you are part of")
 	conversationCmd.Flags().Int("open", -1, "input: <conversation_index>. provides all the messages of a conversation")
 	conversationCmd.Flags().Int("delete", -1, "input: <conversation_index>. deletes the entire conversation")
+	conversationCmd.Flags().Int("mark-read", -1, "input: <conversation_index>. clears the unread count of a conversation")
+	conversationCmd.Flags().Bool("mark-all-read", false, "clears the unread count of every conversation")
+	conversationCmd.Flags().Bool("unread", false, "prints the total number of unread messages, fast enough for shell prompts")
 	conversationCmd.Flags().IntVar(&conversationIndex, "index", -1, "input: <conversation_index>. this will be used along with message command and its flags")
 
 	// adding local flags to message command
-	messageCmd.Flags().String("new", "", "input: <new_message>")
+	messageCmd.Flags().String("new", "", "input: <new_message>. use - to read the message from stdin")
+	messageCmd.Flags().Lookup("new").NoOptDefVal = newTextFromArgs
+	messageCmd.Flags().Bool("editor", false, "writes the message of --new or --reply in $EDITOR")
+	messageCmd.Flags().String("at", "", "schedules the message of --new or --reply for this local time, e.g. \"2026-10-19 09:00\"")
+	messageCmd.Flags().Duration("in", 0, "schedules the message of --new or --reply to be sent this long from now, e.g. 2h")
 	messageCmd.Flags().Int("edit", -1, "input: <message_index> <edited_message>")
 	messageCmd.Flags().Int("delete", -1, "input: <message_index>")
+	messageCmd.Flags().Int("reply", -1, "input: <message_index> <reply_message>. replies to a message of the conversation")
+	messageCmd.Flags().Int("thread", -1, "input: <message_index>. shows a message along with all replies to it")
+	messageCmd.Flags().String("attach", "", "input: <file_path> [caption]. sends a file, interrupted uploads resume when run again")
+	messageCmd.Flags().Int("download", -1, "input: <message_index> [directory]. saves the file attached to a message")
+	messageCmd.Flags().Int("react", -1, "input: <message_index> <emoji>. reacts to a message of the conversation")
+	messageCmd.Flags().Int("unreact", -1, "input: <message_index> [emoji]. takes back a reaction, or all of yours without an emoji")
+	messageCmd.Flags().Int("reactions", -1, "input: <message_index>. lists who reacted to a message")
+	messageCmd.Flags().Int("info", -1, "input: <message_index>. shows delivery status and, in groups, who has read the message")
 }
@@ -0,0 +1,300 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/harshvardha/TerTerChatCLI/internal"
+	"github.com/spf13/cobra"
+)
+
+// this function sends a command to the deamon process over the unix socket
+// and returns everything the deamon writes back before closing the connection
+func sendDeamonCommand(command string) ([]byte, error) {
+	conn, err := net.DialTimeout(socketType, getSocketAddress(), 1*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(command + "\n")); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(conn)
+}
+
+// this function launches the deamon process in the background
+// the deamon writes its own output to the rotating log file
+func startDeamonProcess() (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	deamonProcess := exec.Command(executable, "runDeamon")
+	deamonProcess.SysProcAttr = deamonProcessAttributes()
+	if err = deamonProcess.Start(); err != nil {
+		return 0, err
+	}
+
+	// releasing the process so that it is not left as a zombie once it exits
+	pid := deamonProcess.Process.Pid
+	if err = deamonProcess.Process.Release(); err != nil {
+		log.Printf("Error releasing deamon process: %v", err)
+	}
+
+	return pid, nil
+}
+
+// this function waits until the deamon stops accepting connections on the socket
+func waitForDeamonToStop(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !isDeamonRunning() {
+			return true
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	return false
+}
+
+// this function makes sure user has logged in before starting the deamon
+// the deamon authenticates itself to the server with the stored jwt
+func checkStoredLogin() error {
+	if _, err := os.Stat(internal.TokenFile); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New("no stored login found, please connect using 'TerTer user --connect'")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// this function polls the deamon until it has either completed the handshake
+// with server or recorded an error while trying
+func waitForDeamonConnection(timeout time.Duration) (internal.StatusReport, error) {
+	report := internal.StatusReport{}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		response, err := sendDeamonCommand("status json")
+		if err == nil {
+			if err = json.Unmarshal(response, &report); err != nil {
+				return report, err
+			}
+			if report.Connected || len(report.LastError) > 0 {
+				return report, nil
+			}
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	return report, errors.New("timed out waiting for deamon to connect to server")
+}
+
+// this function prints the outcome of the deamon's first connection attempt
+func printDeamonConnection() {
+	report, err := waitForDeamonConnection(15 * time.Second)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !report.Connected {
+		fmt.Printf("Error connecting to server: %s\n", report.LastError)
+		return
+	}
+	fmt.Println("Connected to server")
+}
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Manage the background deamon process",
+	Long: `The 'daemon' command allows you to start, stop, restart and inspect the
+	background deamon process which keeps the connection to the server alive.`,
+}
+
+var daemonStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the deamon process using the last login",
+	Run: func(cmd *cobra.Command, args []string) {
+		if isDeamonRunning() {
+			fmt.Println("Deamon is already running")
+			return
+		}
+
+		if err := checkStoredLogin(); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		// running the deamon inside this process, useful for debugging
+		foreground, _ := cmd.Flags().GetBool("foreground")
+		if foreground {
+			if err := internal.StartDeamon(); err != nil {
+				log.Printf("Error starting deamon process: %v", err)
+			}
+			return
+		}
+
+		pid, err := startDeamonProcess()
+		if err != nil {
+			fmt.Printf("Error starting deamon process: %v\n", err)
+			return
+		}
+		fmt.Printf("Deamon service started with PID %d.\n", pid)
+		printDeamonConnection()
+	},
+}
+
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the deamon process",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !isDeamonRunning() {
+			fmt.Println("Deamon is not running")
+			return
+		}
+
+		response, err := sendDeamonCommand("disconnect")
+		if err != nil {
+			fmt.Printf("Error stopping deamon process: %v\n", err)
+			return
+		}
+		fmt.Print(string(response))
+	},
+}
+
+var daemonRestartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Restart the deamon process using the last login",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkStoredLogin(); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if isDeamonRunning() {
+			if _, err := sendDeamonCommand("disconnect"); err != nil {
+				fmt.Printf("Error stopping deamon process: %v\n", err)
+				return
+			}
+			if !waitForDeamonToStop(10 * time.Second) {
+				fmt.Println("Deamon did not stop in time")
+				return
+			}
+		}
+
+		pid, err := startDeamonProcess()
+		if err != nil {
+			fmt.Printf("Error starting deamon process: %v\n", err)
+			return
+		}
+		fmt.Printf("Deamon service restarted with PID %d.\n", pid)
+		printDeamonConnection()
+	},
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the deamon process is running",
+	Run: func(cmd *cobra.Command, args []string) {
+		statusCommand := "status"
+		asJson, _ := cmd.Flags().GetBool("json")
+		if asJson {
+			statusCommand += " json"
+		}
+		response, err := sendDeamonCommand(statusCommand)
+		if err != nil {
+			fmt.Println("Deamon is not running")
+			return
+		}
+		if !asJson {
+			fmt.Printf("Deamon is running. Socket: %s\n", getSocketAddress())
+		}
+		fmt.Print(string(response))
+	},
+}
+
+var daemonLogsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Print the log file of the deamon process",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := internal.LoadConfig()
+		if err != nil {
+			log.Printf("error loading config: %v", err)
+		}
+
+		follow, _ := cmd.Flags().GetBool("follow")
+		if err = printDeamonLogs(config.Log.File, follow); err != nil {
+			fmt.Printf("Error reading deamon logs: %v\n", err)
+		}
+	},
+}
+
+// this function prints the log file and with follow it keeps printing
+// new lines as they are written, reopening the file when it gets rotated
+func printDeamonLogs(logFilePath string, follow bool) error {
+	logFile, err := os.Open(logFilePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		logFile.Close()
+	}()
+
+	for {
+		if _, err = io.Copy(os.Stdout, logFile); err != nil {
+			return err
+		}
+		if !follow {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+
+		// checking if the log file was rotated while we were reading it
+		current, err := os.Stat(logFilePath)
+		if err != nil {
+			continue
+		}
+		opened, err := logFile.Stat()
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(current, opened) {
+			// printing whatever was written to the old file before reopening
+			if _, err = io.Copy(os.Stdout, logFile); err != nil {
+				return err
+			}
+			logFile.Close()
+			if logFile, err = os.Open(logFilePath); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func init() {
+	daemonCmd.AddCommand(daemonStartCmd)
+	daemonCmd.AddCommand(daemonStopCmd)
+	daemonCmd.AddCommand(daemonRestartCmd)
+	daemonCmd.AddCommand(daemonStatusCmd)
+	daemonCmd.AddCommand(daemonLogsCmd)
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonStartCmd.Flags().Bool("foreground", false, "run the deamon in this terminal and print logs here, useful for debugging")
+	daemonStatusCmd.Flags().Bool("json", false, "print the connection status as json")
+	daemonLogsCmd.Flags().BoolP("follow", "f", false, "keep printing new log lines as they are written")
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	systemdServiceName = "terter.service"
+	systemdSocketName  = "terter.socket"
+)
+
+// user unit which runs the deamon with readiness and watchdog notifications
+const systemdServiceTemplate = `[Unit]
+Description=TerTer chat deamon
+After=network-online.target
+Requires=%s
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart=%s runDeamon
+WorkingDirectory=%s
+WatchdogSec=%d
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=default.target
+`
+
+// socket unit which lets systemd own cli.sock and start the deamon on demand
+const systemdSocketUnit = `[Unit]
+Description=TerTer chat deamon control socket
+
+[Socket]
+ListenStream=%t/terter/cli.sock
+SocketMode=0600
+DirectoryMode=0700
+
+[Install]
+WantedBy=sockets.target
+`
+
+// this function writes the service and socket units in the systemd user directory
+func writeSystemdUnits(watchdog time.Duration) (string, error) {
+	if err := checkStoredLogin(); err != nil {
+		return "", err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	configDirectory, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	unitDirectory := filepath.Join(configDirectory, "systemd", "user")
+	if err = os.MkdirAll(unitDirectory, 0755); err != nil {
+		return "", err
+	}
+
+	service := fmt.Sprintf(systemdServiceTemplate, systemdSocketName, executable, workingDirectory, int(watchdog.Seconds()))
+	if err = os.WriteFile(filepath.Join(unitDirectory, systemdServiceName), []byte(service), 0644); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(filepath.Join(unitDirectory, systemdSocketName), []byte(systemdSocketUnit), 0644); err != nil {
+		return "", err
+	}
+
+	return unitDirectory, nil
+}
+
+// this function runs systemctl for the user manager and prints its output
+func runSystemctl(args ...string) error {
+	systemctl := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+	systemctl.Stdout = os.Stdout
+	systemctl.Stderr = os.Stderr
+	return systemctl.Run()
+}
+
+var daemonInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install the deamon as a service managed by the system",
+	Run: func(cmd *cobra.Command, args []string) {
+		useSystemd, _ := cmd.Flags().GetBool("systemd")
+		if !useSystemd {
+			fmt.Println("Please choose a service manager, supported: --systemd")
+			return
+		}
+
+		// systemd has to own cli.sock so a manually started deamon must be stopped first
+		if isDeamonRunning() {
+			fmt.Println("Deamon is running, please stop it using 'TerTer daemon stop' before installing")
+			return
+		}
+
+		watchdog, _ := cmd.Flags().GetDuration("watchdog")
+		unitDirectory, err := writeSystemdUnits(watchdog)
+		if err != nil {
+			fmt.Printf("Error writing systemd units: %v\n", err)
+			return
+		}
+		fmt.Printf("Wrote %s and %s to %s\n", systemdServiceName, systemdSocketName, unitDirectory)
+
+		if err = runSystemctl("daemon-reload"); err != nil {
+			fmt.Printf("Error reloading systemd: %v\n", err)
+			return
+		}
+		if err = runSystemctl("enable", "--now", systemdSocketName, systemdServiceName); err != nil {
+			fmt.Printf("Error enabling systemd units: %v\n", err)
+			return
+		}
+		fmt.Println("Deamon installed and started by systemd")
+	},
+}
+
+func init() {
+	daemonCmd.AddCommand(daemonInstallCmd)
+
+	daemonInstallCmd.Flags().Bool("systemd", false, "generate and enable a systemd user unit for the deamon")
+	daemonInstallCmd.Flags().Duration("watchdog", 2*time.Minute, "restart the deamon when the server has not pinged it for this long")
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package cmd
+
+import "syscall"
+
+// process attributes which detach the deamon process from the terminal
+// so that it keeps running after the command which started it exits
+func deamonProcessAttributes() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Setsid: true,
+	}
+}
@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// process attributes which detach the deamon process from the console
+// so that it keeps running after the command which started it exits
+func deamonProcessAttributes() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		HideWindow:    true,
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | windows.DETACHED_PROCESS,
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/harshvardha/TerTerChatCLI/internal"
+	"github.com/spf13/cobra"
+)
+
+// this function reads --since as either a duration back from now or a local time
+func parseSince(value string) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	since, err := time.ParseInLocation(commandTimeLayout, value, time.Local)
+	if err != nil {
+		return since, fmt.Errorf("invalid --since %q, expected a duration like 2h or a time like %q", value, commandTimeLayout)
+	}
+
+	return since, nil
+}
+
+// eventsCmd represents the events command
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Inspect the journal of events received by the deamon",
+	Long: `The deamon appends every frame it receives from the server to the event
+	journal, both as it was read and as it was parsed. The 'events' command lets
+	you query the journal and replay recorded frames to reproduce problems.`,
+}
+
+var eventsLogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Print the events recorded in the journal",
+	Example: `  TerTer events log --since 2h
+  TerTer events log --since "2026-10-19 09:00" --type NEW_MESSAGE,EDIT_MESSAGE
+  TerTer events log --type NEW_MESSAGE --json > new_messages.jsonl`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := internal.LoadConfig()
+		if err != nil {
+			fmt.Printf("Error loading config: %v\n", err)
+			return
+		}
+
+		var since time.Time
+		if value, _ := cmd.Flags().GetString("since"); len(value) > 0 {
+			if since, err = parseSince(value); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		types, _ := cmd.Flags().GetStringSlice("type")
+		for index := range types {
+			types[index] = strings.ToUpper(types[index])
+		}
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		printed := 0
+		err = internal.ReadJournal(config.Journal, func(entry internal.JournalEntry) error {
+			if entry.ReceivedAt.Before(since) {
+				return nil
+			}
+			if len(types) > 0 && !slices.Contains(types, entry.Type) {
+				return nil
+			}
+			printed++
+
+			// json lines can be saved to a file and passed to events replay
+			if asJSON {
+				line, err := json.Marshal(entry)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(line))
+				return nil
+			}
+
+			data := entry.Parsed
+			if len(data) == 0 {
+				data = entry.Payload
+			}
+			fmt.Printf("%s %-8s %s %s %s\n", entry.ReceivedAt.Local().Format("2006-01-02 15:04:05"), entry.Source, entry.Type, entry.ID, string(data))
+			if len(entry.Error) > 0 {
+				fmt.Printf("    error: %s\n    raw: %s\n", entry.Error, entry.Raw)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Error reading event journal: %v\n", err)
+			return
+		}
+		if printed == 0 && !asJSON {
+			fmt.Println("No events found")
+		}
+	},
+}
+
+var eventsReplayCmd = &cobra.Command{
+	Use:   "replay <file>",
+	Short: "Publish the frames recorded in a journal file to the running deamon again",
+	Long: `Replays every frame of a journal file through the event bus of the deamon
+	to reproduce how its payloads are decoded, logged, counted by status and applied
+	to the presence table. Replayed frames show no notifications, send no
+	acknowledgements, run no hooks or webhooks and leave the unread counts, receipts,
+	reactions and cached members untouched, so the same file can be replayed as often
+	as needed. They are not deduplicated and do not move the event cursor.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			fmt.Printf("Error resolving %s: %v\n", args[0], err)
+			return
+		}
+		if _, err = os.Stat(path); err != nil {
+			fmt.Printf("Error reading %s: %v\n", args[0], err)
+			return
+		}
+		if !isDeamonRunning() {
+			fmt.Println("Deamon is not running, start it with 'TerTer daemon start'")
+			return
+		}
+
+		response, err := sendDeamonCommand("replay " + path)
+		if err != nil {
+			fmt.Printf("Error replaying events: %v\n", err)
+			return
+		}
+		fmt.Print(string(response))
+	},
+}
+
+func init() {
+	eventsCmd.AddCommand(eventsLogCmd)
+	eventsCmd.AddCommand(eventsReplayCmd)
+	rootCmd.AddCommand(eventsCmd)
+
+	eventsLogCmd.Flags().String("since", "", "only events received after this duration ago or local time")
+	eventsLogCmd.Flags().StringSlice("type", nil, "only events of these types, comma separated")
+	eventsLogCmd.Flags().Bool("json", false, "print the journal entries as json lines")
+}
@@ -18,12 +18,6 @@ import (
 	"github.com/spf13/pflag"
 )
 
-// custom type only for group command
-type member struct {
-	ID       uuid.UUID
-	Username string
-}
-
 // function to get groups map from groups json file
 func getGroupsMapFromJsonFile() map[int]utility.GroupConversation {
 	groupsMap := make(map[int]utility.GroupConversation)
@@ -41,8 +35,8 @@ func getGroupsMapFromJsonFile() map[int]utility.GroupConversation {
 }
 
 // function to get members map from members json file
-func getGroupMembersMapFromJsonFile(groupID string) map[int]member {
-	membersMap := make(map[int]member)
+func getGroupMembersMapFromJsonFile(groupID string) map[int]utility.GroupMember {
+	membersMap := make(map[int]utility.GroupMember)
 	membersJsonData, err := os.ReadFile(fmt.Sprintf("%s_members.json", groupID))
 	if err != nil {
 		log.Printf("error reading from group members json file: %v", err)
@@ -305,13 +299,13 @@ var groupCmd = &cobra.Command{
 				case http.StatusOK:
 
 					type responseBody struct {
-						Members     []member `json:"members"`
-						AccessToken string   `json:"access_token"`
+						Members     []utility.GroupMember `json:"members"`
+						AccessToken string                `json:"access_token"`
 					}
 					groupMembers := utility.DecodeResponseBody(response.Body, &responseBody{}).(*responseBody)
 					if groupMembers != nil {
 						// printing group members and saving them into a json file with naming pattern "<group_id>_members.json"
-						membersMap := make(map[int]member)
+						membersMap := make(map[int]utility.GroupMember)
 						for index, value := range groupMembers.Members {
 							fmt.Printf("%d - %s", index+1, value.Username)
 							membersMap[index] = value
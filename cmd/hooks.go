@@ -0,0 +1,90 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/harshvardha/TerTerChatCLI/internal"
+	"github.com/spf13/cobra"
+)
+
+// hooksCmd represents the hooks command
+var hooksCmd = &cobra.Command{
+	Use:   "hooks",
+	Short: "Inspect the executables run by the deamon on server events",
+	Long: `Hooks are configured per event in the "hooks" section of config.json, e.g.
+
+	"hooks": {
+		"timeout": "10s",
+		"max_concurrent": 4,
+		"events": {
+			"NEW_MESSAGE": [{"command": "C:/scripts/ticket.exe", "args": ["--queue", "chat"]}],
+			"*": [{"command": "C:/scripts/audit.exe", "timeout": "2s"}]
+		}
+	}
+
+	The deamon runs every hook of an event with {"event", "id", "payload"} as json on
+	stdin and TERTER_EVENT, TERTER_EVENT_ID, TERTER_MESSAGE_ID, TERTER_SENDER_ID,
+	TERTER_SENDER_USERNAME, TERTER_GROUP_ID and TERTER_DRY_RUN in its environment.`,
+}
+
+var hooksTestCmd = &cobra.Command{
+	Use:   "test <event>",
+	Short: "Run the hooks of an event once with a sample payload",
+	Example: `  TerTer hooks test NEW_MESSAGE
+  TerTer hooks test ADD_USER_TO_GROUP --payload event.json`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := strings.ToUpper(args[0])
+		config, err := internal.LoadConfig()
+		if err != nil {
+			fmt.Printf("Error loading config: %v\n", err)
+			return
+		}
+
+		var payload []byte
+		payloadFile, _ := cmd.Flags().GetString("payload")
+		if len(payloadFile) > 0 {
+			if payload, err = os.ReadFile(payloadFile); err != nil {
+				fmt.Printf("Error reading payload: %v\n", err)
+				return
+			}
+			if !json.Valid(payload) {
+				fmt.Printf("Payload in %s is not valid json\n", payloadFile)
+				return
+			}
+		} else if payload, err = internal.SampleEventPayload(name); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		results := internal.TestHooks(config.Hooks, name, payload)
+		if len(results) == 0 {
+			fmt.Printf("No hooks configured for %s\n", name)
+			return
+		}
+		for _, result := range results {
+			if result.Err != nil {
+				fmt.Printf("%s: failed with exit code %d after %s: %v\n", result.Command, result.ExitCode, result.Duration.Round(time.Millisecond), result.Err)
+			} else {
+				fmt.Printf("%s: ok after %s\n", result.Command, result.Duration.Round(time.Millisecond))
+			}
+			if len(result.Output) > 0 {
+				fmt.Println(result.Output)
+			}
+		}
+	},
+}
+
+func init() {
+	hooksCmd.AddCommand(hooksTestCmd)
+	rootCmd.AddCommand(hooksCmd)
+
+	hooksTestCmd.Flags().String("payload", "", "json file used as the event payload instead of a sample")
+}
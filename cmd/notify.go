@@ -0,0 +1,186 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harshvardha/TerTerChatCLI/internal"
+	"github.com/spf13/cobra"
+)
+
+// layout used for absolute times given on the command line
+const commandTimeLayout = "2006-01-02 15:04"
+
+// notifyCmd represents the notify command
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Manage desktop notifications",
+	Long: `The 'notify' command allows you to control which server events are shown
+	as desktop notifications by the deamon process.`,
+}
+
+var notifyRulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Manage the rules evaluated before a message is notified",
+	Long: `Rules are evaluated by the deamon before every new or edited message is notified.
+
+	mute       silence a conversation, optionally until a time
+	dnd        silence everything within a daily time window
+	mentions   in groups only notify messages mentioning @you
+	keyword    highlight messages matching a keyword or regular expression,
+	           highlights get through mutes and mentions rules
+	own-edits  do not notify edits of your own messages`,
+}
+
+var notifyRulesAddCmd = &cobra.Command{
+	Use:       "add <mute|dnd|mentions|keyword|own-edits>",
+	Short:     "Add a notification rule",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: internal.RuleKinds,
+	Example: `  TerTer notify rules add mute --conversation 3 --for 2h
+  TerTer notify rules add dnd --from 22:00 --to 07:00
+  TerTer notify rules add mentions --conversation 5
+  TerTer notify rules add keyword --pattern "deploy|outage" --regex
+  TerTer notify rules add own-edits`,
+	Run: func(cmd *cobra.Command, args []string) {
+		rule, err := notifyRuleFromFlags(cmd, args[0])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		rules, err := internal.LoadNotifyRules()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if rule, err = rules.Add(rule); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err = internal.SaveNotifyRules(rules); err != nil {
+			fmt.Printf("Error saving notification rules: %v\n", err)
+			return
+		}
+		fmt.Printf("Added rule %d: %s\n", rule.ID, rule)
+	},
+}
+
+var notifyRulesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the notification rules",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rules, err := internal.LoadNotifyRules()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if len(rules.Rules) == 0 {
+			fmt.Println("No notification rules")
+			return
+		}
+
+		now := time.Now()
+		for _, rule := range rules.Rules {
+			if rule.Expired(now) {
+				fmt.Printf("%d - %s (expired)\n", rule.ID, rule)
+				continue
+			}
+			fmt.Printf("%d - %s\n", rule.ID, rule)
+		}
+	},
+}
+
+var notifyRulesRemoveCmd = &cobra.Command{
+	Use:   "remove <id>",
+	Short: "Remove a notification rule by the id shown in list",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("Invalid rule id: %s\n", args[0])
+			return
+		}
+
+		rules, err := internal.LoadNotifyRules()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !rules.Remove(id) {
+			fmt.Printf("No rule with id %d\n", id)
+			return
+		}
+		if err = internal.SaveNotifyRules(rules); err != nil {
+			fmt.Printf("Error saving notification rules: %v\n", err)
+			return
+		}
+		fmt.Printf("Removed rule %d\n", id)
+	},
+}
+
+// this function builds a rule of given kind from the flags of add command
+func notifyRuleFromFlags(cmd *cobra.Command, kind string) (internal.NotifyRule, error) {
+	rule := internal.NotifyRule{Kind: kind}
+
+	if cmd.Flags().Changed("conversation") {
+		index, _ := cmd.Flags().GetInt("conversation")
+		conversationID, name, isGroup, err := resolveConversation(index)
+		if err != nil {
+			return rule, err
+		}
+		if kind == internal.RuleMentions && !isGroup {
+			return rule, errors.New("mentions rule only applies to group conversations")
+		}
+		rule.ConversationID = conversationID
+		rule.ConversationName = name
+	}
+
+	if kind == internal.RuleMute {
+		duration, _ := cmd.Flags().GetDuration("for")
+		until, _ := cmd.Flags().GetString("until")
+		switch {
+		case duration > 0 && len(until) > 0:
+			return rule, errors.New("use either --for or --until")
+		case duration > 0:
+			rule.Until = time.Now().Add(duration)
+		case len(until) > 0:
+			parsed, err := time.ParseInLocation(commandTimeLayout, until, time.Local)
+			if err != nil {
+				return rule, fmt.Errorf("invalid --until %q, expected %q", until, commandTimeLayout)
+			}
+			rule.Until = parsed
+		}
+	}
+
+	rule.From, _ = cmd.Flags().GetString("from")
+	rule.To, _ = cmd.Flags().GetString("to")
+	rule.Pattern, _ = cmd.Flags().GetString("pattern")
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	rule.Regex, _ = cmd.Flags().GetBool("regex")
+
+	return rule, nil
+}
+
+func init() {
+	notifyRulesCmd.AddCommand(notifyRulesAddCmd)
+	notifyRulesCmd.AddCommand(notifyRulesListCmd)
+	notifyRulesCmd.AddCommand(notifyRulesRemoveCmd)
+	notifyCmd.AddCommand(notifyRulesCmd)
+	rootCmd.AddCommand(notifyCmd)
+
+	notifyRulesAddCmd.Flags().Int("conversation", 0, "index of the conversation as shown by 'conversation --list'")
+	notifyRulesAddCmd.Flags().Duration("for", 0, "mute the conversation for this long, e.g. 2h")
+	notifyRulesAddCmd.Flags().String("until", "", "mute the conversation until this local time, e.g. \"2026-10-19 18:00\"")
+	notifyRulesAddCmd.Flags().String("from", "", "start of the do not disturb window, HH:MM")
+	notifyRulesAddCmd.Flags().String("to", "", "end of the do not disturb window, HH:MM")
+	notifyRulesAddCmd.Flags().String("pattern", "", "keyword to highlight")
+	notifyRulesAddCmd.Flags().Bool("regex", false, "treat the keyword pattern as a regular expression")
+}
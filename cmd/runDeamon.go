@@ -12,9 +12,24 @@ import (
 
 // runDeamonCmd represents the runDeamon command
 var runDeamonCmd = &cobra.Command{
-	Use: "runDeamon",
+	Use:    "runDeamon",
+	Hidden: true,
+	Args:   cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := internal.StartDeamon(args[0]); err != nil {
+		// sending all the deamon output to the rotating log file
+		config, err := internal.LoadConfig()
+		if err != nil {
+			log.Printf("Error loading config, using defaults: %v", err)
+		}
+		logFile, err := internal.OpenDeamonLog(config.Log)
+		if err != nil {
+			log.Printf("Error opening deamon log file: %v", err)
+			return
+		}
+		defer logFile.Close()
+		log.SetOutput(logFile)
+
+		if err := internal.StartDeamon(); err != nil {
 			log.Printf("Error starting deamon process: %v", err)
 		}
 	},
@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal"
+	"github.com/spf13/cobra"
+)
+
+// this function reads the time a message is scheduled for from --at or --in
+// the second value is false when the message is to be sent right away
+func scheduledTime(cmd *cobra.Command) (time.Time, bool, error) {
+	at, _ := cmd.Flags().GetString("at")
+	in, _ := cmd.Flags().GetDuration("in")
+	switch {
+	case len(at) > 0 && in != 0:
+		return time.Time{}, false, errors.New("use either --at or --in")
+	case len(at) > 0:
+		sendAt, err := time.ParseInLocation(commandTimeLayout, at, time.Local)
+		if err != nil {
+			return sendAt, false, fmt.Errorf("invalid --at %q, expected %q", at, commandTimeLayout)
+		}
+		if !sendAt.After(time.Now()) {
+			return sendAt, false, fmt.Errorf("--at %q has already passed", at)
+		}
+		return sendAt, true, nil
+	case in < 0:
+		return time.Time{}, false, fmt.Errorf("invalid --in %s, it must be positive", in)
+	case in > 0:
+		return time.Now().Add(in), true, nil
+	default:
+		return time.Time{}, false, nil
+	}
+}
+
+// this function checks that --at and --in are only given along with a message to
+// send, the other message flags act right away and would ignore them
+func checkScheduleFlags(cmd *cobra.Command) error {
+	if !cmd.Flags().Changed("at") && !cmd.Flags().Changed("in") {
+		return nil
+	}
+	for _, name := range []string{"edit", "delete", "react", "unreact", "reactions", "info", "thread", "download", "attach"} {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("--at and --in can not be used with --%s, only messages sent with --new or --reply can be scheduled", name)
+		}
+	}
+	if !cmd.Flags().Changed("new") && !cmd.Flags().Changed("reply") && !cmd.Flags().Changed("editor") {
+		return errors.New("--at and --in schedule the message of --new or --reply")
+	}
+
+	return nil
+}
+
+// this function changes the schedule through the deamon which sends the messages
+// the file is changed directly when the deamon is not running, the message is then
+// sent once the deamon starts, the text after "ok" in the response is returned
+func updateSchedule(argument string, fallback func() (string, error)) (string, error) {
+	if !isDeamonRunning() {
+		return fallback()
+	}
+
+	response, err := sendDeamonCommand("schedule " + argument)
+	if err != nil {
+		return "", err
+	}
+	status, value, _ := strings.Cut(strings.TrimSpace(string(response)), " ")
+	if status != "ok" {
+		return "", errors.New(strings.TrimPrefix(strings.TrimSpace(string(response)), "error: "))
+	}
+	return value, nil
+}
+
+// this function schedules a message to the conversation at index
+// parentID is set when the message is a reply to an earlier message
+func scheduleMessage(index int, description string, parentID uuid.UUID, sendAt time.Time) (int, error) {
+	conversationID, conversationName, isGroup, err := resolveConversation(index)
+	if err != nil {
+		return 0, err
+	}
+
+	message := internal.ScheduledMessage{
+		ConversationID:   conversationID,
+		ConversationName: conversationName,
+		IsGroup:          isGroup,
+		Description:      description,
+		ParentID:         parentID,
+		SendAt:           sendAt.UTC(),
+	}
+	messageJson, err := json.Marshal(message)
+	if err != nil {
+		return 0, fmt.Errorf("error marshalling scheduled message: %w", err)
+	}
+
+	id, err := updateSchedule("add "+string(messageJson), func() (string, error) {
+		added, err := internal.AddScheduled(message)
+		if err != nil {
+			return "", err
+		}
+		fmt.Println("Deamon is not running, the message will be sent once it is started with 'TerTer daemon start'")
+		return strconv.Itoa(added.ID), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// scheduledCmd represents the scheduled command
+var scheduledCmd = &cobra.Command{
+	Use:   "scheduled",
+	Short: "Manage messages scheduled with message --at or --in",
+	Long: `Messages scheduled with 'message --new TEXT --at "2026-10-19 09:00"' or
+	'--in 2h' are kept by the deamon and sent when they are due, also after the
+	deamon is restarted. The 'scheduled' command lists, changes and cancels them.`,
+}
+
+var scheduledListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the messages waiting to be sent",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		scheduled, err := internal.LoadSchedule()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if len(scheduled.Messages) == 0 {
+			fmt.Println("No scheduled messages")
+			return
+		}
+
+		for _, message := range scheduled.Messages {
+			status := ""
+			switch {
+			case message.Failed:
+				status = fmt.Sprintf(" (not sent: %s)", message.LastError)
+			case !message.SendingSince.IsZero():
+				status = " (sending)"
+			case message.Attempts > 0:
+				status = fmt.Sprintf(" (retrying at %s: %s)", message.NextAttempt.Local().Format(commandTimeLayout), message.LastError)
+			}
+			fmt.Printf("%d - %s to %s: %s%s\n", message.ID, message.SendAt.Local().Format(commandTimeLayout), message.ConversationName, quoteSnippet(message.Description), status)
+		}
+	},
+}
+
+var scheduledCancelCmd = &cobra.Command{
+	Use:   "cancel <id>",
+	Short: "Cancel a scheduled message by the id shown in list",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("Invalid scheduled message id: %s\n", args[0])
+			return
+		}
+
+		_, err = updateSchedule(fmt.Sprintf("cancel %d", id), func() (string, error) {
+			return "", internal.CancelScheduled(id)
+		})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Cancelled scheduled message %d\n", id)
+	},
+}
+
+var scheduledEditCmd = &cobra.Command{
+	Use:   "edit <id> [new_message]",
+	Short: "Change the text or the time of a scheduled message",
+	Example: `  TerTer scheduled edit 3 "standup moved to 10:00"
+  TerTer scheduled edit 3 --at "2026-10-20 09:00"
+  TerTer scheduled edit 3 --in 30m --editor`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("Invalid scheduled message id: %s\n", args[0])
+			return
+		}
+		scheduled, err := internal.LoadSchedule()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		index := -1
+		for position, message := range scheduled.Messages {
+			if message.ID == id {
+				index = position
+			}
+		}
+		if index < 0 {
+			fmt.Printf("No scheduled message with id %d\n", id)
+			return
+		}
+		message := scheduled.Messages[index]
+
+		edit := internal.ScheduleEdit{ID: id}
+		if sendAt, ok, err := scheduledTime(cmd); err != nil {
+			fmt.Println(err)
+			return
+		} else if ok {
+			edit.SendAt = sendAt.UTC()
+		}
+
+		text := strings.Join(args[1:], " ")
+		if editor, _ := cmd.Flags().GetBool("editor"); editor {
+			// starting from the current text unless a new one was given
+			if len(text) == 0 {
+				text = message.Description
+			}
+			if text, err = composeInEditor(message.ConversationID, message.IsGroup, text, nil); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		if len(text) > 0 {
+			if edit.Description, err = validateMessage(text); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		if len(edit.Description) == 0 && edit.SendAt.IsZero() {
+			fmt.Println("Nothing to change, give a new message, --at, --in or --editor")
+			return
+		}
+
+		editJson, err := json.Marshal(edit)
+		if err != nil {
+			fmt.Printf("Error marshalling scheduled message edit: %v\n", err)
+			return
+		}
+		_, err = updateSchedule("edit "+string(editJson), func() (string, error) {
+			return "", internal.EditScheduled(edit)
+		})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Changed scheduled message %d\n", id)
+	},
+}
+
+func init() {
+	scheduledCmd.AddCommand(scheduledListCmd)
+	scheduledCmd.AddCommand(scheduledCancelCmd)
+	scheduledCmd.AddCommand(scheduledEditCmd)
+	rootCmd.AddCommand(scheduledCmd)
+
+	scheduledEditCmd.Flags().String("at", "", "send the message at this local time instead, e.g. \"2026-10-19 09:00\"")
+	scheduledEditCmd.Flags().Duration("in", 0, "send the message this long from now instead, e.g. 2h")
+	scheduledEditCmd.Flags().Bool("editor", false, "change the text of the message in $EDITOR")
+}
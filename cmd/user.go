@@ -7,26 +7,23 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"strings"
-	"syscall"
 	"time"
 
+	"github.com/harshvardha/TerTerChatCLI/internal"
 	"github.com/harshvardha/TerTerChatCLI/utility"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
-	"golang.org/x/sys/windows"
 )
 
 const (
-	socketType     = "unix"
-	socketFileName = "cli.sock"
+	socketType = "unix"
 )
 
 // function to send http request
@@ -41,12 +38,10 @@ func CreateRequest(verb string, url string, body []byte) (*http.Request, error)
 }
 
 // function to return the socket file path
-// this also allows for future updations where we can
-// provide user the fexibility to configure path for the
-// socket file and then in other parts of codebase we can use this
-// function to get the path for the socket file
+// the deamon process owns the location of the socket file
+// which lives inside a per-user runtime directory
 func getSocketAddress() string {
-	return filepath.Join(os.TempDir(), socketFileName)
+	return internal.SocketPath()
 }
 
 // this function is important for checking the existence of the socket file
@@ -62,6 +57,48 @@ func isDeamonRunning() bool {
 	return true
 }
 
+// this function stores the number of new messages per conversation from the login summary
+// conversations are matched by name with the ones cached by conversation --list
+// in one_to_one.json and group.json,
+// the ones never listed on this machine are counted from the next new message
+func seedUnreadCounts(latestMessages *utility.LatestMessages) error {
+	oneToOneConversations := make(map[int]utility.OneToOneConversation)
+	if err := utility.ReadJSONFile("one_to_one.json", &oneToOneConversations); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading one to one conversations: %w", err)
+	}
+	groupConversations := make(map[int]utility.GroupConversation)
+	if err := utility.ReadJSONFile("group.json", &groupConversations); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading group conversations: %w", err)
+	}
+
+	summary := make([]internal.UnreadSummary, 0)
+	for _, message := range latestMessages.OneToOneMessages {
+		for _, conversation := range oneToOneConversations {
+			if conversation.Username == message.Sender {
+				summary = append(summary, internal.UnreadSummary{Key: conversation.ReceiverID.String(), Name: message.Sender, Count: int(message.TotalNewMessages)})
+				break
+			}
+		}
+	}
+	for _, message := range latestMessages.GroupMessages {
+		for _, conversation := range groupConversations {
+			if conversation.GroupName == message.GroupName && conversation.GroupID.Valid {
+				summary = append(summary, internal.UnreadSummary{Key: conversation.GroupID.UUID.String(), Name: message.GroupName, IsGroup: true, Count: int(message.TotalNewMessages)})
+				break
+			}
+		}
+	}
+	if len(summary) == 0 {
+		return nil
+	}
+
+	summaryJson, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return updateUnread("seed "+string(summaryJson), func() error { return internal.ApplyUnreadSummary(summary) })
+}
+
 // userCmd represents the user command
 var userCmd = &cobra.Command{
 	Use:   "user",
@@ -139,6 +176,11 @@ var userCmd = &cobra.Command{
 								return
 							}
 						}
+
+						// the login summary tells how many messages arrived while we were away
+						if err = seedUnreadCounts(responseData); err != nil {
+							log.Printf("error storing unread counts: %v", err)
+						}
 					}
 				default:
 					responseError := utility.DecodeResponseBody(response.Body, &utility.ErrorResponse{}).(*utility.ErrorResponse)
@@ -155,28 +197,30 @@ var userCmd = &cobra.Command{
 					return
 				}
 
-				// // creating a log file for deamon process to log any error
-				deamonLogFile, err := os.OpenFile("deamon_output.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+				// starting the deamon process which authenticates itself with the stored jwt
+				pid, err := startDeamonProcess()
 				if err != nil {
-					fmt.Printf("Error opening log file: %v\n", err)
+					fmt.Printf("Error starting deamon process: %v", err)
 					return
 				}
-				defer deamonLogFile.Close()
+				fmt.Printf("Deamon service started with PID %d.\n", pid)
 
-				// // starting the deamon process
-				cmd := exec.Command("E:/golang_projects/TerTerChatCLI/TerTerChatCLI.exe", "runDeamon", phonenumber)
-				cmd.Stdin = deamonLogFile
-				cmd.Stdout = deamonLogFile
-				cmd.Stderr = deamonLogFile
-				cmd.SysProcAttr = &syscall.SysProcAttr{
-					HideWindow:    true,
-					CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | windows.DETACHED_PROCESS,
+				// waiting for the server to accept or reject the deamon's session
+				report, err := waitForDeamonConnection(15 * time.Second)
+				if err != nil {
+					fmt.Println(err)
+					return
 				}
-				if err = cmd.Start(); err != nil {
-					fmt.Printf("Error starting deamon process: %v", err)
+				if !report.Connected {
+					fmt.Printf("Error connecting to server: %s\n", report.LastError)
+
+					// the deamon is of no use without a session so stopping it
+					if _, err = sendDeamonCommand("disconnect"); err != nil {
+						log.Printf("Error stopping deamon process: %v", err)
+					}
 					return
 				}
-				fmt.Printf("Deamon service started with PID %d.\n", cmd.Process.Pid)
+				fmt.Println("Connected to server")
 			case "disconnect":
 				// initiate a socket connection to unix socket deamon process
 				// and send this command to it to execute required code
@@ -205,25 +249,17 @@ var userCmd = &cobra.Command{
 				conn.Close()
 			case "status":
 				// requesting deamon process to return the status of connection
-				conn, err := net.Dial(socketType, getSocketAddress())
-				if err != nil {
-					log.Println(err)
-					return
-				}
-				if _, err = conn.Write([]byte(f.Name + "\n")); err != nil {
-					log.Printf("Error fetching status of connection: %v", err)
-					return
+				// as plain text or as json when --json is set
+				statusCommand := f.Name
+				if asJson, _ := cmd.Flags().GetBool("json"); asJson {
+					statusCommand += " json"
 				}
-
-				// reading status of connection
-				reader := bufio.NewReader(conn)
-				status, err := reader.ReadBytes('\n')
+				status, err := sendDeamonCommand(statusCommand)
 				if err != nil {
 					log.Printf("Error fetching connection status: %v", err)
 					return
 				}
-				fmt.Println(string(status))
-				conn.Close()
+				fmt.Print(string(status))
 			case "register":
 				phonenumber := f.Value
 
@@ -696,6 +732,7 @@ func init() {
 	userCmd.Flags().StringP("connect", "c", "", "This command will connect you to the server")
 	userCmd.Flags().Bool("disconnect", false, "This command will diconnect you from the sever")
 	userCmd.Flags().Bool("status", false, "This command will tell you the status of connection to server")
+	userCmd.Flags().Bool("json", false, "Print the output of --status as json")
 	userCmd.Flags().StringP("register", "r", "", "This command will help you register for service.\nIt takes username, phonenumber and password as input(space separated)")
 	userCmd.Flags().StringP("search", "s", "", "This command will help you search for a user")
 	userCmd.Flags().Bool("remove", false, "This command will help you delete your account")
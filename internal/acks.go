@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+const (
+	// messages already marked read survive restarts in this file so that
+	// reopening a conversation does not send their read receipts again
+	markedReadFileName = "marked_read.json"
+
+	// the messages marked read longest ago are forgotten beyond this many messages
+	maxMarkedRead = 5000
+)
+
+// ReadRequest is sent by conversation --open to the deamon with the
+// messages it displayed, GroupID is set for group conversations
+type ReadRequest struct {
+	GroupID  uuid.UUID     `json:"group_id,omitzero"`
+	Messages []ReadMessage `json:"messages"`
+}
+
+// ReadMessage is a displayed message which was sent by another user
+type ReadMessage struct {
+	ID       uuid.UUID `json:"id"`
+	SenderID uuid.UUID `json:"sender_id"`
+}
+
+// acknowledger tells the server which messages reached us and which we have read
+type acknowledger struct {
+	privacy PrivacyConfig
+
+	// time at which each message was marked read, keyed by message id
+	markedRead *batchedFile[map[uuid.UUID]time.Time]
+}
+
+// acks is replaced with the configured acknowledger when the deamon starts
+var acks = newAcknowledger(defaultConfig().Privacy)
+
+func newAcknowledger(privacy PrivacyConfig) *acknowledger {
+	return &acknowledger{
+		privacy:    privacy,
+		markedRead: newBatchedFile(markedReadFileName, loadMarkedRead),
+	}
+}
+
+// this function reads the messages marked read by a previous run of the deamon
+func loadMarkedRead() (map[uuid.UUID]time.Time, error) {
+	markedRead := make(map[uuid.UUID]time.Time)
+	if err := utility.ReadJSONFile(markedReadFileName, &markedRead); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return markedRead, fmt.Errorf("error reading %s: %w", markedReadFileName, err)
+	}
+
+	return markedRead, nil
+}
+
+// this function acknowledges a one to one message as received by this client
+// delivery is always acknowledged, only read receipts can be turned off
+func (a *acknowledger) acknowledgeDelivery(event events.Event, message *events.Message) error {
+	if message.GroupID != uuid.Nil {
+		return nil
+	}
+	self, err := currentUser()
+	if err != nil {
+		return err
+	}
+	if message.SenderID == self.UserID {
+		return nil
+	}
+
+	return sendToServer(events.MESSAGE_RECEIVED, events.MessageReceived{ID: message.ID, ReceiverID: self.UserID})
+}
+
+// this function tells the senders of displayed messages that we have read them
+// it returns how many messages were marked, messages already marked are skipped
+func (a *acknowledger) markRead(request ReadRequest) (int, error) {
+	if !a.privacy.ReadReceipts {
+		return 0, nil
+	}
+	self, err := currentUser()
+	if err != nil {
+		return 0, err
+	}
+
+	marked := 0
+	readAt := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, message := range request.Messages {
+		if message.SenderID == self.UserID {
+			continue
+		}
+		alreadyMarked, err := a.alreadyMarked(message.ID)
+		if err != nil {
+			return marked, err
+		}
+		if alreadyMarked {
+			continue
+		}
+
+		if request.GroupID != uuid.Nil {
+			err = sendToServer(events.GROUP_MESSAGE_READ, events.GroupMessageRead{
+				ID:                  message.ID,
+				GroupID:             request.GroupID,
+				GroupMemberID:       self.UserID,
+				GroupMemberUsername: self.Username,
+				ReadAt:              readAt,
+			})
+		} else {
+			err = sendToServer(events.MESSAGE_READ, events.MessageRead{
+				ID:       message.ID,
+				SenderID: message.SenderID,
+				ReaderID: self.UserID,
+				ReadAt:   readAt,
+			})
+		}
+		if err != nil {
+			a.forget(message.ID)
+			return marked, err
+		}
+		marked++
+	}
+
+	return marked, nil
+}
+
+// this function remembers a message as marked read and reports whether it already was
+func (a *acknowledger) alreadyMarked(id uuid.UUID) (bool, error) {
+	alreadyMarked := false
+	err := a.markedRead.update(func(markedRead map[uuid.UUID]time.Time) {
+		if _, alreadyMarked = markedRead[id]; alreadyMarked {
+			return
+		}
+		markedRead[id] = time.Now().UTC()
+
+		if overflow := len(markedRead) - maxMarkedRead; overflow > 0 {
+			ids := make([]uuid.UUID, 0, len(markedRead))
+			for id := range markedRead {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(i, j int) bool { return markedRead[ids[i]].Before(markedRead[ids[j]]) })
+			for _, id := range ids[:overflow] {
+				delete(markedRead, id)
+			}
+		}
+	})
+
+	return alreadyMarked, err
+}
+
+// this function forgets a message whose read receipt could not be sent
+func (a *acknowledger) forget(id uuid.UUID) {
+	a.markedRead.update(func(markedRead map[uuid.UUID]time.Time) {
+		delete(markedRead, id)
+	})
+}
+
+// this function subscribes the acknowledgements to received messages
+// replayed messages were acknowledged when they were first received
+func registerAcknowledgements(bus *events.Bus) {
+	events.On(bus, events.NEW_MESSAGE, events.SkipReplay(func(event events.Event, message *events.Message) error {
+		return acks.acknowledgeDelivery(event, message)
+	}))
+}
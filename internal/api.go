@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+const apiBaseURL = "http://localhost:8080/api/v1"
+
+// http client used by the deamon for REST calls to server
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// this function creates an authenticated json request for the REST api
+func newAPIRequest(verb string, path string, body any) (*http.Request, error) {
+	requestBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(strings.ToUpper(verb), apiBaseURL+path, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := readAuthToken()
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Add("authorization", fmt.Sprintf("bearer %s", token))
+	return request, nil
+}
+
+// this function stores the refreshed access token returned with a response
+func updateAuthToken(accessToken string) {
+	if len(accessToken) == 0 {
+		return
+	}
+	if err := os.WriteFile(TokenFile, []byte(accessToken), 0700); err != nil {
+		log.Printf("error updating auth file: %v", err)
+	}
+}
+
+// this function returns the claims of the stored jwt
+func currentUser() (utility.TokenClaims, error) {
+	token, err := readAuthToken()
+	if err != nil {
+		return utility.TokenClaims{}, err
+	}
+
+	return utility.ParseTokenClaims(token)
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+// the state kept in memory is written at most this often and once more on shutdown
+const batchSaveInterval = 2 * time.Second
+
+// batchedFile keeps the content of a json file in memory so that the event
+// workers do not read and write the whole file for every event
+// updates are applied under its mutex and written together by the goroutine
+// started with start, or right away with save
+type batchedFile[T any] struct {
+	mu     sync.Mutex
+	path   string
+	load   func() (T, error)
+	value  T
+	loaded bool
+
+	// set when the value changed since it was last written
+	dirty bool
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+func newBatchedFile[T any](path string, load func() (T, error)) *batchedFile[T] {
+	return &batchedFile[T]{path: path, load: load}
+}
+
+// this function reads the file on first use, the caller holds the mutex
+func (f *batchedFile[T]) ensureLoaded() error {
+	if f.loaded {
+		return nil
+	}
+	value, err := f.load()
+	if err != nil {
+		return err
+	}
+	f.value = value
+	f.loaded = true
+	return nil
+}
+
+// this function applies an update in memory, it is written by the next save
+func (f *batchedFile[T]) update(apply func(T)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.ensureLoaded(); err != nil {
+		return err
+	}
+	apply(f.value)
+	f.dirty = true
+	return nil
+}
+
+// this function calls view with the value in memory, view must not keep or modify it
+func (f *batchedFile[T]) view(view func(T)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.ensureLoaded(); err != nil {
+		return err
+	}
+	view(f.value)
+	return nil
+}
+
+// this function writes the value when it changed since the last write
+func (f *batchedFile[T]) save() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.dirty {
+		return nil
+	}
+	if err := utility.WriteJSONFile(f.path, f.value); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
+
+// this function writes the value every batchSaveInterval while it keeps changing
+func (f *batchedFile[T]) start() {
+	f.quit = make(chan struct{})
+	f.done = make(chan struct{})
+	go func() {
+		defer close(f.done)
+		ticker := time.NewTicker(batchSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-f.quit:
+				f.logSave()
+				return
+			}
+			f.logSave()
+		}
+	}()
+}
+
+// this function stops the periodic writes after writing the value a last time
+func (f *batchedFile[T]) stop() {
+	if f.quit == nil {
+		return
+	}
+	close(f.quit)
+	<-f.done
+}
+
+func (f *batchedFile[T]) logSave() {
+	if err := f.save(); err != nil {
+		log.Printf("Error saving %s: %v", f.path, err)
+	}
+}
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"log"
+
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+)
+
+// bus on which every event received from server is published
+var bus = newEventBus()
+
+// EventBus returns the bus of deamon process so that other parts of the
+// deamon can subscribe to server events
+func EventBus() *events.Bus {
+	return bus
+}
+
+func newEventBus() *events.Bus {
+	bus := events.NewBus()
+	bus.Use(countEvents)
+	bus.Use(events.Logging(log.Default()))
+	bus.Use(runHooks)
+	bus.Use(deliverWebhooks)
+	registerGroupCache(bus)
+	registerReceipts(bus)
+	registerAcknowledgements(bus)
+	registerUnread(bus)
+	registerPresence(bus)
+	registerReactions(bus)
+	registerNotifications(bus)
+
+	return bus
+}
+
+// middleware which counts the events by name for the status command
+func countEvents(next events.Handler) events.Handler {
+	return func(event events.Event) error {
+		status.recordEvent(event.Name)
+		return next(event)
+	}
+}
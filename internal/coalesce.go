@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+)
+
+// burst collects the messages of one group waiting to be shown as a single notification
+type burst struct {
+	messages     int
+	edits        int
+	highlight    bool
+	latestSender string
+	latestText   string
+	timer        *time.Timer
+}
+
+// coalescer groups bursts of group messages into summaries like
+// "12 new messages in Team Infra" and caps how many summaries are shown per minute
+// direct messages are shown straight away
+type coalescer struct {
+	mu           sync.Mutex
+	window       time.Duration
+	maxPerMinute int
+	bursts       map[uuid.UUID]*burst
+	shown        []time.Time
+	push         func(title string, message string) error
+}
+
+// notifications is replaced with the configured coalescer when the deamon starts
+var notifications = newCoalescer(defaultConfig().Notify, pushNotification)
+
+func newCoalescer(config NotificationConfig, push func(title string, message string) error) *coalescer {
+	return &coalescer{
+		window:       time.Duration(config.CoalesceWindow),
+		maxPerMinute: config.MaxPerMinute,
+		bursts:       make(map[uuid.UUID]*burst),
+		push:         push,
+	}
+}
+
+// this function queues a message which passed the notification rules
+func (c *coalescer) submit(name string, message *events.Message, highlight bool) {
+	if message.GroupID == uuid.Nil {
+		title := message.SenderUsername
+		if highlight {
+			title = "★ " + title
+		}
+		if err := c.push(title, message.Description); err != nil {
+			log.Printf("Error showing notification: %v", err)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	pending, ok := c.bursts[message.GroupID]
+	if !ok {
+		pending = &burst{}
+		c.bursts[message.GroupID] = pending
+	}
+	if name == events.EDIT_MESSAGE {
+		pending.edits++
+	} else {
+		pending.messages++
+	}
+	pending.highlight = pending.highlight || highlight
+	pending.latestSender = message.SenderUsername
+	pending.latestText = message.Description
+	if !ok {
+		groupID := message.GroupID
+		pending.timer = time.AfterFunc(c.window, func() { c.flush(groupID) })
+	}
+	c.mu.Unlock()
+}
+
+// this function shows the summary of a group once its window has passed
+// when the per minute cap is reached the burst keeps collecting until a slot frees up
+func (c *coalescer) flush(groupID uuid.UUID) {
+	c.mu.Lock()
+	pending, ok := c.bursts[groupID]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	for len(c.shown) > 0 && now.Sub(c.shown[0]) >= time.Minute {
+		c.shown = c.shown[1:]
+	}
+	if len(c.shown) >= c.maxPerMinute {
+		wait := c.shown[0].Add(time.Minute).Sub(now)
+		pending.timer = time.AfterFunc(wait, func() { c.flush(groupID) })
+		c.mu.Unlock()
+		return
+	}
+	delete(c.bursts, groupID)
+	c.shown = append(c.shown, now)
+	c.mu.Unlock()
+
+	title, text := pending.summary(groupID)
+	if err := c.push(title, text); err != nil {
+		log.Printf("Error showing notification: %v", err)
+	}
+}
+
+// this function shows every pending burst, used when the deamon shuts down
+func (c *coalescer) stop() {
+	c.mu.Lock()
+	pendingBursts := c.bursts
+	c.bursts = make(map[uuid.UUID]*burst)
+	c.mu.Unlock()
+
+	for groupID, pending := range pendingBursts {
+		pending.timer.Stop()
+		title, text := pending.summary(groupID)
+		if err := c.push(title, text); err != nil {
+			log.Printf("Error showing notification: %v", err)
+		}
+	}
+}
+
+// this function builds the title and text of the notification of a burst
+func (b *burst) summary(groupID uuid.UUID) (string, string) {
+	groupName := cachedGroupName(groupID)
+	if len(groupName) == 0 {
+		groupName = "a group"
+	}
+
+	var title, text string
+	if b.messages+b.edits == 1 {
+		title = fmt.Sprintf("%s in %s", b.latestSender, groupName)
+		text = b.latestText
+	} else {
+		counts := make([]string, 0, 2)
+		if b.messages > 0 {
+			counts = append(counts, plural(b.messages, "new message"))
+		}
+		if b.edits > 0 {
+			counts = append(counts, plural(b.edits, "edit"))
+		}
+		title = fmt.Sprintf("%s in %s", strings.Join(counts, " and "), groupName)
+		text = fmt.Sprintf("latest: %s: %s", b.latestSender, b.latestText)
+	}
+	if b.highlight {
+		title = "★ " + title
+	}
+
+	return title, text
+}
+
+// this function writes a count with its noun like "1 edit" or "12 new messages"
+func plural(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const configFileName = "config.json"
+
+// StateDir returns the per-user directory of files the deamon keeps between runs
+// so that they are found whichever directory a command is started from
+// $XDG_STATE_HOME is preferred, otherwise the user cache directory is used
+func StateDir() string {
+	if stateHome := os.Getenv("XDG_STATE_HOME"); len(stateHome) > 0 {
+		return filepath.Join(stateHome, runtimeDirName)
+	}
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(cacheDir, runtimeDirName)
+	}
+	return getRuntimeDir()
+}
+
+// Duration wraps time.Duration so that it can be written as "24h" or "30m" in config.json
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// configuration for the log file of deamon process
+type LogConfig struct {
+	File       string   `json:"file"`
+	MaxSizeMB  int64    `json:"max_size_mb"`
+	MaxAge     Duration `json:"max_age"`
+	MaxBackups int      `json:"max_backups"`
+}
+
+// configuration for the queue between the reader of server events and their handlers
+type DispatchConfig struct {
+	Workers   int `json:"workers"`
+	QueueSize int `json:"queue_size"`
+}
+
+// configuration for the liveness checks of the connection to server
+type HeartbeatConfig struct {
+	// the deamon pings the server itself after it has been quiet for this long
+	Interval Duration `json:"interval"`
+
+	// the connection is considered dead and reconnected after this long without any data
+	DeadPeerTimeout Duration `json:"dead_peer_timeout"`
+
+	// bounds of the exponential backoff between reconnect attempts
+	ReconnectMin Duration `json:"reconnect_min"`
+	ReconnectMax Duration `json:"reconnect_max"`
+}
+
+// configuration for grouping bursts of messages into a single notification
+type NotificationConfig struct {
+	// messages of a group arriving within this window are shown as one summary
+	CoalesceWindow Duration `json:"coalesce_window"`
+
+	// upper bound of coalesced notifications shown per minute, direct messages are not limited
+	MaxPerMinute int `json:"max_per_minute"`
+}
+
+// a user hook, the executable receives the event json on stdin
+type HookConfig struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+
+	// overrides the timeout of all hooks when set
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// configuration for the executables run on server events
+type HooksConfig struct {
+	Timeout       Duration `json:"timeout"`
+	MaxConcurrent int      `json:"max_concurrent"`
+
+	// hooks are run for events waiting for a free slot until this many are queued
+	MaxQueued int `json:"max_queued"`
+
+	// hooks by event name, hooks under "*" run for every event
+	Events map[string][]HookConfig `json:"events"`
+}
+
+// an http endpoint which receives server events
+type WebhookConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// key of the hmac-sha256 signature sent in X-TerTer-Signature
+	Secret string `json:"secret,omitempty"`
+
+	// names of the events sent to this webhook, every event when empty
+	Events []string `json:"events,omitempty"`
+}
+
+// configuration for the delivery of events to webhooks
+type WebhooksConfig struct {
+	Endpoints []WebhookConfig `json:"endpoints"`
+	Timeout   Duration        `json:"timeout"`
+
+	// a delivery is given up after this many failed attempts
+	MaxAttempts int `json:"max_attempts"`
+
+	// bounds of the exponential backoff between attempts of a delivery
+	RetryMin Duration `json:"retry_min"`
+	RetryMax Duration `json:"retry_max"`
+
+	// oldest deliveries are dropped once this many are waiting
+	MaxQueued int `json:"max_queued"`
+}
+
+// configuration for the journal of every frame received from server
+type JournalConfig struct {
+	File       string `json:"file"`
+	MaxSizeMB  int64  `json:"max_size_mb"`
+	MaxBackups int    `json:"max_backups"`
+}
+
+// configuration for what the deamon tells other users about us
+type PrivacyConfig struct {
+	// senders are told when their messages have been read
+	ReadReceipts bool `json:"read_receipts"`
+}
+
+// configuration for files sent with message --attach
+type AttachmentsConfig struct {
+	MaxSizeMB   int64 `json:"max_size_mb"`
+	ChunkSizeKB int64 `json:"chunk_size_kb"`
+}
+
+// Config holds all the user configurable settings of the deamon process
+type Config struct {
+	Log         LogConfig          `json:"log"`
+	Dispatch    DispatchConfig     `json:"dispatch"`
+	Heartbeat   HeartbeatConfig    `json:"heartbeat"`
+	Notify      NotificationConfig `json:"notifications"`
+	Hooks       HooksConfig        `json:"hooks"`
+	Webhooks    WebhooksConfig     `json:"webhooks"`
+	Journal     JournalConfig      `json:"journal"`
+	Privacy     PrivacyConfig      `json:"privacy"`
+	Attachments AttachmentsConfig  `json:"attachments"`
+}
+
+// this function provides the default configuration used when config.json
+// does not exist or does not set a value
+func defaultConfig() Config {
+	return Config{
+		Log: LogConfig{
+			File:       filepath.Join(StateDir(), "deamon_output.log"),
+			MaxSizeMB:  10,
+			MaxAge:     Duration(24 * time.Hour),
+			MaxBackups: 5,
+		},
+		Dispatch: DispatchConfig{
+			Workers:   4,
+			QueueSize: 256,
+		},
+		Heartbeat: HeartbeatConfig{
+			Interval:        Duration(15 * time.Second),
+			DeadPeerTimeout: Duration(45 * time.Second),
+			ReconnectMin:    Duration(time.Second),
+			ReconnectMax:    Duration(time.Minute),
+		},
+		Notify: NotificationConfig{
+			CoalesceWindow: Duration(5 * time.Second),
+			MaxPerMinute:   10,
+		},
+		Hooks: HooksConfig{
+			Timeout:       Duration(10 * time.Second),
+			MaxConcurrent: 4,
+			MaxQueued:     64,
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:     Duration(10 * time.Second),
+			MaxAttempts: 10,
+			RetryMin:    Duration(5 * time.Second),
+			RetryMax:    Duration(30 * time.Minute),
+			MaxQueued:   1000,
+		},
+		Journal: JournalConfig{
+			File:       "events_journal.jsonl",
+			MaxSizeMB:  20,
+			MaxBackups: 5,
+		},
+		Privacy: PrivacyConfig{
+			ReadReceipts: true,
+		},
+		Attachments: AttachmentsConfig{
+			MaxSizeMB:   25,
+			ChunkSizeKB: 512,
+		},
+	}
+}
+
+// LoadConfig reads config.json and fills every missing value with its default
+func LoadConfig() (Config, error) {
+	config := defaultConfig()
+	configJsonData, err := os.ReadFile(configFileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return config, err
+	}
+
+	if err = json.Unmarshal(configJsonData, &config); err != nil {
+		return defaultConfig(), err
+	}
+	if err = config.validate(); err != nil {
+		return defaultConfig(), err
+	}
+
+	return config, nil
+}
+
+// this function rejects settings which would break the deamon
+func (c Config) validate() error {
+	if c.Heartbeat.Interval <= 0 || c.Heartbeat.ReconnectMin <= 0 {
+		return errors.New("heartbeat interval and reconnect_min must be positive")
+	}
+	if c.Heartbeat.DeadPeerTimeout <= c.Heartbeat.Interval {
+		return errors.New("heartbeat dead_peer_timeout must be longer than interval")
+	}
+	if c.Heartbeat.ReconnectMax < c.Heartbeat.ReconnectMin {
+		return errors.New("heartbeat reconnect_max must not be shorter than reconnect_min")
+	}
+	if c.Notify.CoalesceWindow < 0 || c.Notify.MaxPerMinute <= 0 {
+		return errors.New("notifications coalesce_window must not be negative and max_per_minute must be positive")
+	}
+	if c.Hooks.Timeout <= 0 || c.Hooks.MaxConcurrent <= 0 || c.Hooks.MaxQueued < 0 {
+		return errors.New("hooks timeout and max_concurrent must be positive")
+	}
+	for name, hooks := range c.Hooks.Events {
+		for _, hook := range hooks {
+			if len(hook.Command) == 0 {
+				return fmt.Errorf("hook for %s has no command", name)
+			}
+		}
+	}
+	if c.Webhooks.Timeout <= 0 || c.Webhooks.MaxAttempts <= 0 || c.Webhooks.RetryMin <= 0 || c.Webhooks.MaxQueued <= 0 {
+		return errors.New("webhooks timeout, max_attempts, retry_min and max_queued must be positive")
+	}
+	if c.Webhooks.RetryMax < c.Webhooks.RetryMin {
+		return errors.New("webhooks retry_max must not be shorter than retry_min")
+	}
+	names := make(map[string]bool)
+	for _, webhook := range c.Webhooks.Endpoints {
+		if len(webhook.Name) == 0 || len(webhook.URL) == 0 {
+			return errors.New("every webhook needs a name and url")
+		}
+		if names[webhook.Name] {
+			return fmt.Errorf("webhook name %q is used twice", webhook.Name)
+		}
+		names[webhook.Name] = true
+	}
+	if c.Attachments.MaxSizeMB <= 0 || c.Attachments.ChunkSizeKB <= 0 {
+		return errors.New("attachments max_size_mb and chunk_size_kb must be positive")
+	}
+
+	return nil
+}
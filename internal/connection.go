@@ -2,7 +2,6 @@ package internal
 
 import (
 	"bufio"
-	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -12,290 +11,202 @@ import (
 	"log"
 	"net"
 	"os"
-	"strings"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/go-toast/toast"
-	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/protocol"
 )
 
 const (
-	pingMessage = "_PING_\n"
-	pongMessage = "_PONG_\n"
-	pingTimeout = 5 * time.Second
-	addr        = "localhost:8081"
+	writeTimeout = 5 * time.Second
+	addr         = "localhost:8081"
 
 	// client certificate and key file paths
 	certificateFile = "certificates/client.crt"
 	keyFile         = "certificates/client.key"
-
-	// event names
-	NEW_MESSAGE            = "NEW_MESSAGE"
-	EDIT_MESSAGE           = "EDIT_MESSAGE"
-	DELETE_MESSAGE         = "DELETE_MESSAGE"
-	MESSAGE_RECEIVED       = "MARK_MESSAGE_RECEIVED"
-	GROUP_MESSAGE_READ     = "GROUP_MESSAGE_READ"
-	ADDED_USER_TO_GROUP    = "ADD_USER_TO_GROUP"
-	REMOVE_USER_FROM_GROUP = "REMOVE_USER_FROM_GROUP"
-	MADE_ADMIN             = "MADE_ADMIN"
-	REMOVE_ADMIN           = "REMOVE_ADMIN"
 )
 
 // quit channel to signal close the socket connection to server when disconnect command is called
 var quit = make(chan struct{})
 
-// variable to track status of connection
-var isConnected bool
+// reason recorded when the heartbeat monitor gives up on a quiet connection
+var errDeadPeer = errors.New("server stopped responding to heartbeats")
 
-// group information for group event
-type group struct {
-	id          uuid.UUID
-	username    string
-	phonenumber string
-}
+// session holds the state of a single connection to server
+// a new session is created every time the deamon reconnects
+type session struct {
+	conn      net.Conn
+	decoder   *protocol.Decoder
+	encoder   *protocol.Encoder
+	heartbeat HeartbeatConfig
 
-// all group events will be unmarshalled into this struct
-type groupEvent struct {
-	Name      string `json:"name"`
-	Group     group  `json:"group"`
-	EmittedAt string `json:"emittedAt"`
-}
+	// heartbeats go through control channel so that they never wait behind data frames
+	control chan protocol.Frame
+	writer  chan protocol.Frame
 
-// Message data for NEW_MESSAGE | EDIT_MESSAGE event
-type newOrEditMessage struct {
-	ID             uuid.UUID `json:"id"`
-	GroupID        uuid.UUID `json:"group_id,omitempty"`
-	SenderID       uuid.UUID `json:"sender_id"`
-	SenderUsername string    `json:"sender_username,omitempty"`
-	Description    string    `json:"description"`
-	CreatedAt      string    `json:"created_at,omitempty"`
-	UpdatedAt      string    `json:"updated_at,omitempty"`
-}
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
 
-// Message data for DELETE_MESSAGE event
-type deleteMessage struct {
-	ID       uuid.UUID `json:"id"`
-	SenderID uuid.UUID `json:"sender_id"`
-	GroupID  uuid.UUID `json:"group_id,omitempty"`
-}
+	// unix nano time of the last frame received from server
+	lastReceived atomic.Int64
 
-// Message data for MESSAGE_RECEIVED event
-type markMessageReceived struct {
-	ID         uuid.UUID `json:"id"`
-	ReceiverID uuid.UUID `json:"receiver_id"`
+	pingsMu      sync.Mutex
+	pendingPings map[string]time.Time
 }
 
-// Message data for GROUP_MESSAGE_READ event
-type markGroupMessageRead struct {
-	ID                  uuid.UUID `json:"id"`
-	GroupID             uuid.UUID `json:"group_id"`
-	GroupMemberID       uuid.UUID `json:"group_member_id"`
-	GroupMemberUsername string    `json:"group_member_username"`
-}
-
-func eventParser(event []byte) error {
-	// finding the index of separator pipe '|'
-	pipeIndex := bytes.Index(event, []byte("|"))
-	if pipeIndex == -1 {
-		return errors.New("message malfunctioned")
-	}
-
-	eventName := string(event[:pipeIndex])
-	switch eventName {
-	case NEW_MESSAGE:
-		// show notification for new message
-		message := &newOrEditMessage{}
-		err := json.Unmarshal(event[pipeIndex+1:], message)
-		if err != nil {
-			return err
-		}
+// session currently connected to server, nil while disconnected
+var activeSession atomic.Pointer[session]
 
-		notification := toast.Notification{
-			AppID:   "TerTerChat",
-			Title:   message.SenderUsername,
-			Message: message.Description,
-		}
-		err = notification.Push()
-		if err != nil {
-			return err
-		}
-	case EDIT_MESSAGE:
-		message := &newOrEditMessage{}
-		err := json.Unmarshal(event[pipeIndex+1:], message)
-		if err != nil {
-			return err
-		}
+var errNotConnected = errors.New("not connected to server")
 
-		notification := toast.Notification{
-			AppID:   "TerTerChat",
-			Title:   message.SenderUsername,
-			Message: message.Description,
-		}
-		err = notification.Push()
-		if err != nil {
-			return err
-		}
-	case DELETE_MESSAGE:
-		message := &deleteMessage{}
-		err := json.Unmarshal(event[pipeIndex+1:], message)
-		if err != nil {
-			return err
-		}
+// this function queues an event for the server on the active session
+// it never blocks, the event is lost when the deamon is offline or the queue is full
+func sendToServer(name string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
 
-		notification := toast.Notification{
-			AppID:   "TerTerChat",
-			Title:   "message deleted",
-			Message: message.ID.String(),
-		}
-		if err = notification.Push(); err != nil {
-			return err
-		}
-	case MESSAGE_RECEIVED:
-		message := &markMessageReceived{}
-		err := json.Unmarshal(event[pipeIndex+1:], message)
-		if err != nil {
-			return err
-		}
+	s := activeSession.Load()
+	if s == nil {
+		return errNotConnected
+	}
+	select {
+	case s.writer <- protocol.Frame{Type: name, Payload: data}:
+		return nil
+	case <-s.done:
+		return errNotConnected
+	default:
+		return fmt.Errorf("write queue is full, dropping %s event", name)
+	}
+}
 
-		notification := toast.Notification{
-			AppID:   "TerTerChat",
-			Title:   "message received",
-			Message: message.ID.String(),
-		}
-		if err = notification.Push(); err != nil {
-			return err
-		}
-	case GROUP_MESSAGE_READ:
-		message := &markGroupMessageRead{}
-		err := json.Unmarshal(event[pipeIndex+1:], message)
-		if err != nil {
-			return err
-		}
+// this function ends the session, the first caller decides the reason
+func (s *session) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		close(s.done)
+		s.conn.Close()
+	})
+}
 
-		notification := toast.Notification{
-			AppID:   "TerTerChat",
-			Title:   "group message read",
-			Message: message.ID.String(),
-		}
-		if err = notification.Push(); err != nil {
-			return err
-		}
-	case ADDED_USER_TO_GROUP:
-		message := &groupEvent{}
-		err := json.Unmarshal(event[pipeIndex+1:], message)
-		if err != nil {
-			return err
-		}
+// this function sends a ping of our own and remembers when it was sent
+// so that the round trip time can be measured when the pong arrives
+func (s *session) sendPing() {
+	id := strconv.FormatInt(time.Now().UnixNano(), 10)
 
-		notification := toast.Notification{
-			AppID:   "TerTerChat",
-			Title:   "added user to group",
-			Message: message.Group.username + message.Group.phonenumber,
-		}
-		if err = notification.Push(); err != nil {
-			return err
-		}
-	case REMOVE_USER_FROM_GROUP:
-		message := &groupEvent{}
-		err := json.Unmarshal(event[pipeIndex+1:], message)
-		if err != nil {
-			return err
+	s.pingsMu.Lock()
+	for pingID, sentAt := range s.pendingPings {
+		if time.Since(sentAt) > time.Duration(s.heartbeat.DeadPeerTimeout) {
+			delete(s.pendingPings, pingID)
 		}
+	}
+	s.pendingPings[id] = time.Now()
+	s.pingsMu.Unlock()
 
-		notification := toast.Notification{
-			AppID:   "TerTerChat",
-			Title:   "removed user from group" + message.Group.id.String(),
-			Message: message.Group.username + message.Group.phonenumber,
-		}
-		if err = notification.Push(); err != nil {
-			return err
-		}
-	case MADE_ADMIN:
-		message := &groupEvent{}
-		err := json.Unmarshal(event[pipeIndex+1:], message)
-		if err != nil {
-			return err
-		}
+	select {
+	case s.control <- protocol.Frame{Type: protocol.TypePing, ID: id}:
+	default:
+		log.Println("Control channel is full, dropping ping")
+	}
+}
 
-		notification := toast.Notification{
-			AppID:   "TerTerChat",
-			Title:   "made user admin",
-			Message: message.Group.username + message.Group.phonenumber,
-		}
-		if err = notification.Push(); err != nil {
-			return err
-		}
-	case REMOVE_ADMIN:
-		message := &groupEvent{}
-		err := json.Unmarshal(event[pipeIndex+1:], message)
-		if err != nil {
-			return err
-		}
+// this function measures the round trip time of the ping answered by this pong
+// pongs of the text format carry no id so they answer the oldest pending ping
+func (s *session) recordPong(id string) {
+	s.pingsMu.Lock()
+	defer s.pingsMu.Unlock()
 
-		notification := toast.Notification{
-			AppID:   "TerTerChat",
-			Title:   "removed user from admin",
-			Message: message.Group.username + message.Group.phonenumber,
-		}
-		if err = notification.Push(); err != nil {
-			return err
+	if len(id) == 0 {
+		for pingID, sentAt := range s.pendingPings {
+			if len(id) == 0 || sentAt.Before(s.pendingPings[id]) {
+				id = pingID
+			}
 		}
-	default:
-		return errors.New("invalid event")
 	}
-
-	return nil
+	sentAt, ok := s.pendingPings[id]
+	if !ok {
+		return
+	}
+	delete(s.pendingPings, id)
+	status.recordRoundTrip(time.Since(sentAt))
 }
 
-func readFromConnection(connection net.Conn, writer chan<- []byte, wg *sync.WaitGroup) {
+func readFromConnection(s *session, wg *sync.WaitGroup) {
 	log.Println("Starting to read from server")
-	reader := bufio.NewReader(connection)
 	defer func() {
 		log.Println("Stopping to read from server")
 		wg.Done()
 	}()
 
 	// reading from connection
+	// there is no read deadline, heartbeat goroutine closes the connection when server goes quiet
 	for {
-		connection.SetReadDeadline(time.Now().Add(pingTimeout * time.Second))
-		message, err := reader.ReadBytes('\n')
+		frame, err := s.decoder.Decode()
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				log.Println("connnection timedout.")
+			if errors.Is(err, protocol.ErrMalformedFrame) {
+				// a malformed frame does not break the stream so we can keep reading
+				log.Printf("Error parsing the message: %v\n", err)
+				journal.record(SourceServer, frame, s.decoder.Raw(), err)
+				s.lastReceived.Store(time.Now().UnixNano())
 				continue
 			} else if err == io.EOF {
 				log.Println("server closed the connection")
 			} else {
-				log.Println("error reading from server")
+				log.Printf("error reading from server: %v", err)
 			}
 
+			s.closeWithError(err)
+			return
+		}
+		s.lastReceived.Store(time.Now().UnixNano())
+
+		// start parsing the frame to pass it to appropriate event handler
+		// if the frame is ping then pass pong to writeToConnection
+		switch frame.Type {
+		case protocol.TypePing:
+			// server pings double as the systemd watchdog heartbeat
+			status.recordPing()
+			notifyWatchdog()
+			// replying on the control channel which is written before any other frame
+			// a stale pong is useless so it is dropped when the channel is full
 			select {
-			case shutdownChannel <- struct{}{}:
-				log.Println("Signaled deamon shutdown via shutdown channel due to read error")
+			case s.control <- protocol.Frame{Type: protocol.TypePong, ID: frame.ID}:
 			default:
-				log.Println("Shutdown channel is blocked. Already received a signal")
+				log.Println("Control channel is full, dropping pong")
 			}
-			return
-		}
-
-		// start parsing the message to pass it to appropriate event handler
-		// if the message is pong then pass it to writeToConnection
-		msgString := strings.TrimSpace(string(message))
-		switch msgString {
-		case pingMessage[:len(pingMessage)-1]:
-			writer <- []byte(pongMessage)
+		case protocol.TypePong:
+			notifyWatchdog()
+			s.recordPong(frame.ID)
 		default:
-			// parse events
-			if err = eventParser(message); err != nil {
-				log.Printf("Error parsing the message: %v\n", err)
-				continue
-			}
+			// handing the event to the workers so that a slow handler can not delay heartbeats
+			journal.record(SourceServer, frame, s.decoder.Raw(), nil)
+			eventQueue.enqueue(frame)
 		}
 	}
 }
 
-func writeToConnection(connection net.Conn, writer <-chan []byte, wg *sync.WaitGroup) {
+// this function passes an event frame to its handler exactly once
+// frames already handled before a replay or catch-up sync are skipped
+func processFrame(frame protocol.Frame) {
+	if cursor.isDuplicate(frame) {
+		log.Printf("Skipping duplicate %s event", frame.Type)
+		return
+	}
+
+	if err := bus.Publish(frame.Type, frame.ID, frame.Payload); err != nil {
+		log.Printf("Error parsing the message: %v\n", err)
+	}
+	cursor.advance(frame)
+}
+
+// this function writes frames to the server
+// control frames like pong are always written before pending data frames
+func writeToConnection(s *session, wg *sync.WaitGroup) {
 	log.Println("Starting to write to server")
 	defer func() {
 		log.Println("Stopping write to server")
@@ -303,47 +214,70 @@ func writeToConnection(connection net.Conn, writer <-chan []byte, wg *sync.WaitG
 	}()
 
 	for {
+		var frame protocol.Frame
 		select {
-		case message, ok := <-writer:
-			log.Println("writing pong message to server")
-			if !ok {
-				log.Println("writer channel closed")
+		case frame = <-s.control:
+		default:
+			select {
+			case frame = <-s.control:
+			case frame = <-s.writer:
+			case <-s.done:
 				return
 			}
+		}
+		log.Printf("writing %s frame to server", frame.Type)
+
+		s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := s.encoder.Encode(frame); err != nil {
+			log.Printf("error writing to server: %v", err)
+			s.closeWithError(err)
+			return
+		}
+	}
+}
 
-			connection.SetWriteDeadline(time.Now().Add(pingTimeout))
-			if _, err := connection.Write(message); err != nil {
-				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-					log.Println("connection timedout")
-					continue
-				}
+// this function detects a half open connection
+// when server has been quiet for a heartbeat interval we ping it ourselves
+// and when nothing arrives within the dead peer timeout the session is closed
+// so that the deamon reconnects
+func monitorHeartbeat(s *session, wg *sync.WaitGroup) {
+	defer wg.Done()
 
+	ticker := time.NewTicker(time.Duration(s.heartbeat.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			quietFor := time.Since(time.Unix(0, s.lastReceived.Load()))
+			if quietFor >= time.Duration(s.heartbeat.DeadPeerTimeout) {
+				log.Printf("No data from server for %s, treating connection as dead", quietFor.Round(time.Second))
+				s.closeWithError(errDeadPeer)
 				return
 			}
-		case <-quit:
-			return
+			if quietFor >= time.Duration(s.heartbeat.Interval) {
+				s.sendPing()
+			}
 		}
 	}
 }
 
-// this function will initiate the tls tcp socket connection
-func connect(phonenumber string, deamonWG *sync.WaitGroup) {
-	defer deamonWG.Done()
-
+// this function loads the certificates and builds the TLS configuration for server connection
+func loadTLSConfig() (*tls.Config, tls.Certificate, error) {
 	// loading rootCA and adding it to the trust store so that it can accept server's certificate
 	rootCAs := x509.NewCertPool()
 	caCert, err := os.ReadFile("certificates/ca.crt")
 	if err != nil {
-		log.Printf("Error connecting to server: %v", err)
-		return
+		return nil, tls.Certificate{}, err
 	}
 	rootCAs.AppendCertsFromPEM(caCert)
 
 	// loading client certificates and private key
 	certificate, err := tls.LoadX509KeyPair(certificateFile, keyFile)
 	if err != nil {
-		log.Printf("Error connecting to server: %v", err)
-		return
+		return nil, tls.Certificate{}, err
 	}
 
 	// configuring TLS for client
@@ -356,42 +290,127 @@ func connect(phonenumber string, deamonWG *sync.WaitGroup) {
 		KeyLogWriter: os.Stdout,
 	}
 
-	// creating a dialer to connect to server and send the user phonenumber
-	conn, err := tls.Dial("tcp", addr, tlsConfig)
+	return tlsConfig, certificate, nil
+}
+
+// this function will initiate the tls tcp socket connection
+// and keep reconnecting with exponential backoff whenever it drops
+func connect(deamonWG *sync.WaitGroup, heartbeat HeartbeatConfig) {
+	defer deamonWG.Done()
+
+	tlsConfig, certificate, err := loadTLSConfig()
 	if err != nil {
 		log.Printf("Error connecting to server: %v", err)
+		status.setError(err)
 		return
 	}
-	isConnected = true
 
-	// sending phonenumber
-	if _, err = conn.Write([]byte(phonenumber)); err != nil {
-		fmt.Printf("Error connecting to server: %v", err)
-		return
+	backoff := time.Duration(heartbeat.ReconnectMin)
+	for {
+		startedAt := time.Now()
+		err = runSession(tlsConfig, certificate, heartbeat)
+		select {
+		case <-quit:
+			return
+		default:
+		}
+
+		// retrying can not fix a rejected session or a missing login
+		if errors.Is(err, ErrHandshakeRejected) || errors.Is(err, os.ErrNotExist) {
+			log.Printf("Not reconnecting: %v", err)
+			return
+		}
+
+		// a session which stayed up for a while resets the backoff
+		if time.Since(startedAt) > time.Duration(heartbeat.DeadPeerTimeout) {
+			backoff = time.Duration(heartbeat.ReconnectMin)
+		}
+		log.Printf("Connection lost (%v), reconnecting in %s", err, backoff)
+		select {
+		case <-quit:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Duration(heartbeat.ReconnectMax))
+		status.recordReconnect()
 	}
+}
 
-	// creating a channel for communication between readFromConnection and writeToConnection
-	writer := make(chan []byte, 10)
+// this function runs a single session from dial until the connection closes
+// it returns the reason the session ended, nil when the deamon is shutting down
+func runSession(tlsConfig *tls.Config, certificate tls.Certificate, heartbeat HeartbeatConfig) error {
+	// creating a dialer to connect to server
+	conn, err := tls.Dial("tcp", addr, tlsConfig)
+	if err != nil {
+		log.Printf("Error connecting to server: %v", err)
+		status.setError(err)
+		return err
+	}
 
-	go func() {
-		<-quit
-		fmt.Println("Closing connection to server")
+	// identifying this session to the server with the jwt received on login
+	// the same reader is used afterwards for events so that nothing buffered is lost
+	// the resume cursor lets the server replay whatever we missed while offline
+	resume := cursor.resume()
+	reader := bufio.NewReaderSize(conn, maxHelloReplySize)
+	result, err := handshake(conn, reader, resume)
+	if err != nil {
+		log.Printf("Error connecting to server: %v", err)
+		status.setError(err)
 		conn.Close()
-		close(writer)
+		return err
+	}
+	log.Printf("Handshake accepted, session: %s", result.SessionID)
+
+	// falling back to REST sync when server can not replay missed events
+	if resume != nil && !result.Replay {
+		go catchUp(resume.Since)
+	}
+
+	s := &session{
+		conn:         conn,
+		decoder:      protocol.NewDecoder(reader),
+		encoder:      protocol.NewLegacyEncoder(conn),
+		heartbeat:    heartbeat,
+		control:      make(chan protocol.Frame, 16),
+		writer:       make(chan protocol.Frame, 64),
+		done:         make(chan struct{}),
+		pendingPings: make(map[string]time.Time),
+	}
+	s.lastReceived.Store(time.Now().UnixNano())
+
+	// switching to framed protocol only when server supports it
+	if slices.Contains(result.Capabilities, protocol.Capability) {
+		s.encoder = protocol.NewEncoder(conn)
+	}
+	status.setConnected(conn.ConnectionState(), certificate, result.SessionID)
+	defer status.setDisconnected()
+	activeSession.Store(s)
+	defer activeSession.CompareAndSwap(s, nil)
+
+	// telling systemd that the deamon is ready once the session is accepted
+	if err = sdNotify("READY=1\nSTATUS=Connected to " + addr); err != nil {
+		log.Printf("Error notifying systemd: %v", err)
+	}
+
+	go func() {
+		select {
+		case <-quit:
+			log.Println("Closing connection to server")
+			s.closeWithError(nil)
+		case <-s.done:
+		}
 	}()
 
 	var wg sync.WaitGroup
-	wg.Add(2)
-	go readFromConnection(conn, writer, &wg)
-	go writeToConnection(conn, writer, &wg)
+	wg.Add(3)
+	go readFromConnection(s, &wg)
+	go writeToConnection(s, &wg)
+	go monitorHeartbeat(s, &wg)
 	wg.Wait()
 	log.Println("Connection to server was closed!")
-}
 
-func isConnectionAlive() string {
-	if isConnected {
-		return "connected\n"
+	if s.err != nil {
+		status.setError(s.err)
 	}
-
-	return "disconnected\n"
+	return s.err
 }
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -17,6 +19,7 @@ import (
 const (
 	socketFileName = "cli.sock"
 	socketType     = "unix"
+	runtimeDirName = "terter"
 )
 
 var (
@@ -24,34 +27,116 @@ var (
 	shutdownChannel = make(chan struct{}) // channel use to shutdown deamon process when disconnect command is executed
 )
 
-func getSocketPath() string {
-	return filepath.Join(os.TempDir(), socketFileName)
+// SocketPath returns the path of the unix socket used for IPC between the
+// deamon process and other commands. The socket lives inside a per-user
+// runtime directory so that other users on the machine cannot reach it
+func SocketPath() string {
+	return filepath.Join(getRuntimeDir(), socketFileName)
 }
 
-// main entry point for deamon process
-func StartDeamon(phonenumber string) error {
+// this function returns the per-user runtime directory
+// $XDG_RUNTIME_DIR is preferred because it is already private to the user
+// otherwise we fall back to a directory inside temp dir suffixed with the uid
+func getRuntimeDir() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); len(runtimeDir) > 0 {
+		return filepath.Join(runtimeDir, runtimeDirName)
+	}
+
+	dirName := runtimeDirName
+	if uid := os.Getuid(); uid != -1 {
+		dirName = fmt.Sprintf("%s-%d", runtimeDirName, uid)
+	}
+	return filepath.Join(os.TempDir(), dirName)
+}
+
+// this function creates the runtime directory if it does not exist
+// and makes sure that only the current user can access it
+func ensureRuntimeDir(runtimeDir string) error {
+	if err := os.MkdirAll(runtimeDir, 0700); err != nil {
+		return err
+	}
+
+	// using Lstat so that a symlink planted by another user is not followed
+	info, err := os.Lstat(runtimeDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("runtime path %s is not a directory", runtimeDir)
+	}
+	if err = checkOwner(info); err != nil {
+		return err
+	}
+
+	// tightening the permissions if the directory was created with a looser mode
+	if info.Mode().Perm() != 0700 {
+		if err = os.Chmod(runtimeDir, 0700); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// this function creates the unix socket listener used for IPC
+func listenOnSocket(socketPath string) (net.Listener, error) {
 	// this is a very crucial step for unix sockets
 	// it remove any old socket file that might exist
 	// this prevents the "address already in use" error if the daemon previously
 	// crashed without properly cleaning up
-	socketPath := getSocketPath()
+	if err := ensureRuntimeDir(filepath.Dir(socketPath)); err != nil {
+		return nil, err
+	}
 	if err := os.RemoveAll(socketPath); err != nil {
-		return err
+		return nil, err
 	}
 
 	// creating a unix listener. This will also create the socket file
 	// this socket file will be used for IPC between this process and other commands
 	// who need to communicate with this process
 	listener, err := net.Listen(socketType, socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the owner of the deamon should be able to connect to the socket
+	if err = os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// main entry point for deamon process
+func StartDeamon() error {
+	config, err := LoadConfig()
+	if err != nil {
+		log.Printf("Error loading config, using defaults: %v", err)
+	}
+	socketPath := SocketPath()
+
+	// when systemd socket activates cli.sock it passes us the listener
+	// and owns the socket file, so we must not create or remove it
+	listener, err := activationListener()
 	if err != nil {
 		return err
 	}
+	socketActivated := listener != nil
+	if !socketActivated {
+		if listener, err = listenOnSocket(socketPath); err != nil {
+			return err
+		}
+	}
 	isDeamonRunning = true
 	defer func() {
 		log.Println("Closing unix socket listener")
 		listener.Close()
 
 		// cleaning up the socket file on gracefull shutdown
+		if socketActivated {
+			return
+		}
 		if err = os.Remove(socketPath); err != nil {
 			log.Printf("Error removing socket file: %v", err)
 		}
@@ -81,6 +166,11 @@ func StartDeamon(phonenumber string) error {
 			log.Println("Received internal shutdown command")
 		}
 
+		// letting systemd know that we are going down on purpose
+		if err := sdNotify("STOPPING=1"); err != nil {
+			log.Printf("Error notifying systemd: %v", err)
+		}
+
 		// shutting down tcp connection when signal recieved on shutdown channel
 		close(quit)
 
@@ -88,8 +178,44 @@ func StartDeamon(phonenumber string) error {
 		listener.Close()
 	}()
 
+	// journaling every frame received from server
+	if err = journal.open(config.Journal); err != nil {
+		log.Printf("Error opening event journal, events will not be journaled: %v", err)
+	}
+	defer journal.close()
+
+	// acknowledging received messages and sending read receipts
+	acks = newAcknowledger(config.Privacy)
+	acks.markedRead.start()
+
+	// grouping bursts of group messages into summary notifications
+	notifications = newCoalescer(config.Notify, pushNotification)
+
+	// running the user hooks configured for server events
+	hooks = newHookRunner(config.Hooks)
+
+	// posting server events to the configured webhooks
+	webhooks = newWebhookSink(config.Webhooks)
+	webhooks.start()
+
+	// starting the workers which handle events received from server
+	eventQueue = newDispatcher(config.Dispatch.Workers, config.Dispatch.QueueSize, processFrame)
+	eventQueue.start()
+
+	// loading the cursor of the last event handled by a previous run
+	cursor.load()
+	cursor.start()
+
+	// writing the receipts, unread counts and reactions kept in memory in batches
+	receipts.start()
+	unread.start()
+	reactions.start()
+
+	// sending scheduled messages, including those which became due while the deamon was not running
+	scheduler.start()
+
 	// starting the TCP socket connection to server
-	go connect(phonenumber, &wg)
+	go connect(&wg, config.Heartbeat)
 
 	// main loop which will continue to accept connections from other processes or commands
 	// until any OS signal like SIGINT/SIGTERM is emitted or disconnect command is executed
@@ -98,7 +224,7 @@ func StartDeamon(phonenumber string) error {
 		if err != nil {
 			// if the error is due to listener being closed, we will exit gracefully
 			if errors.Is(err, net.ErrClosed) {
-				fmt.Printf("Listener closed, exiting.")
+				log.Println("Listener closed, exiting.")
 				break
 			}
 
@@ -114,23 +240,54 @@ func StartDeamon(phonenumber string) error {
 
 	// waiting for all the goroutines launched from this Deamon Process to finish
 	wg.Wait()
+	eventQueue.stop()
+	cursor.stop()
+	receipts.stop()
+	unread.stop()
+	reactions.stop()
+	acks.markedRead.stop()
+	notifications.stop()
+	hooks.wait()
+	webhooks.stop()
+	scheduler.stop()
 	log.Println("Deamon process has fully shutdown")
 	return nil
 }
 
 func handleConnection(connection net.Conn) {
+	defer connection.Close()
 	log.Printf("handleConnection launched for: %s", connection.RemoteAddr().String())
+
+	// verifying that the connecting process belongs to the same user as the deamon
+	// before executing any command sent by it
+	if err := verifyPeer(connection); err != nil {
+		log.Printf("Rejecting connection: %v", err)
+		return
+	}
+
 	reader := bufio.NewReader(connection)
 	command, err := reader.ReadBytes('\n')
 	if err != nil {
-		fmt.Printf("Error reading from process: %v", err)
+		log.Printf("Error reading from process: %v", err)
 		return
 	}
-	log.Println(string(command))
-
-	switch strings.TrimSpace(string(command)) {
+	// commands are written as "<name> <argument>"
+	// only the name is logged, arguments carry message text
+	commandName, argument, _ := strings.Cut(strings.TrimSpace(string(command)), " ")
+	log.Printf("Received %s command", commandName)
+	switch commandName {
 	case "status":
-		if _, err = connection.Write([]byte(isConnectionAlive())); err != nil {
+		// status can be requested as plain text or as json
+		report := status.snapshot()
+		response := []byte(report.String())
+		if argument == "json" {
+			if response, err = json.Marshal(report); err != nil {
+				log.Printf("Error marshalling status report: %v", err)
+				return
+			}
+			response = append(response, '\n')
+		}
+		if _, err = connection.Write(response); err != nil {
 			log.Printf("Error writing response to client: %v", err)
 		}
 	case "disconnect":
@@ -138,5 +295,151 @@ func handleConnection(connection net.Conn) {
 			log.Printf("Error writing response to client: %v", err)
 		}
 		shutdownChannel <- struct{}{}
+	case "read":
+		// marking messages displayed by conversation --open as read
+		request := ReadRequest{}
+		response := ""
+		if err = json.Unmarshal([]byte(argument), &request); err != nil {
+			response = fmt.Sprintf("invalid read request: %v\n", err)
+		} else if !acks.privacy.ReadReceipts {
+			response = "read receipts are turned off\n"
+		} else if marked, err := acks.markRead(request); err != nil {
+			response = fmt.Sprintf("marked %d messages read, then failed: %v\n", marked, err)
+		} else {
+			response = fmt.Sprintf("marked %d messages read\n", marked)
+		}
+		if _, err = connection.Write([]byte(response)); err != nil {
+			log.Printf("Error writing response to client: %v", err)
+		}
+	case "unread":
+		// "unread" answers with the total for shell prompts, "unread json" with every
+		// conversation and "unread clear <id|all>" or "unread seed <json>" change the counts
+		if _, err = connection.Write(handleUnreadCommand(argument)); err != nil {
+			log.Printf("Error writing response to client: %v", err)
+		}
+	case "presence":
+		// presence and typing state of every user seen since the deamon started
+		response, err := json.Marshal(presence.snapshot())
+		if err != nil {
+			log.Printf("Error marshalling presence: %v", err)
+			return
+		}
+		if _, err = connection.Write(append(response, '\n')); err != nil {
+			log.Printf("Error writing response to client: %v", err)
+		}
+	case "typing":
+		// "typing start <json>" and "typing stop <json>" are sent by the composer
+		action, value, _ := strings.Cut(argument, " ")
+		request := TypingRequest{}
+		response := "ok\n"
+		if err = json.Unmarshal([]byte(value), &request); err != nil {
+			response = fmt.Sprintf("error: invalid typing request: %v\n", err)
+		} else if err = sendTyping(action == "start", request.ReceiverID, request.GroupID); err != nil {
+			response = fmt.Sprintf("error: %v\n", err)
+		}
+		if _, err = connection.Write([]byte(response)); err != nil {
+			log.Printf("Error writing response to client: %v", err)
+		}
+	case "react":
+		// reactions are sent by message --react and --unreact
+		request := ReactionRequest{}
+		response := "ok\n"
+		if err = json.Unmarshal([]byte(argument), &request); err != nil {
+			response = fmt.Sprintf("error: invalid reaction request: %v\n", err)
+		} else if err = sendReaction(request); err != nil {
+			response = fmt.Sprintf("error: %v\n", err)
+		}
+		if _, err = connection.Write([]byte(response)); err != nil {
+			log.Printf("Error writing response to client: %v", err)
+		}
+	case "schedule":
+		// "schedule add <json>", "schedule edit <json>" and "schedule cancel <id>"
+		// are sent by message --at/--in and the scheduled command
+		if _, err = connection.Write(handleScheduleCommand(argument)); err != nil {
+			log.Printf("Error writing response to client: %v", err)
+		}
+	case "replay":
+		// publishing the frames of a journal file again, the path is absolute
+		response := ""
+		replayed, failed, err := replayJournal(argument)
+		if err != nil {
+			response = fmt.Sprintf("error replaying %s after %d events: %v\n", argument, replayed, err)
+		} else {
+			response = fmt.Sprintf("replayed %d events, %d failed\n", replayed, failed)
+		}
+		if _, err = connection.Write([]byte(response)); err != nil {
+			log.Printf("Error writing response to client: %v", err)
+		}
+	}
+}
+
+// this function executes the schedule command and returns its response
+// the id of an added message is returned after "ok"
+func handleScheduleCommand(argument string) []byte {
+	action, value, _ := strings.Cut(argument, " ")
+	var err error
+	switch action {
+	case "add":
+		message := ScheduledMessage{}
+		if err = json.Unmarshal([]byte(value), &message); err == nil {
+			if message, err = AddScheduled(message); err == nil {
+				scheduler.wakeUp()
+				return fmt.Appendf(nil, "ok %d\n", message.ID)
+			}
+		}
+	case "edit":
+		edit := ScheduleEdit{}
+		if err = json.Unmarshal([]byte(value), &edit); err == nil {
+			err = EditScheduled(edit)
+		}
+	case "cancel":
+		var id int
+		if id, err = strconv.Atoi(value); err == nil {
+			err = CancelScheduled(id)
+		}
+	default:
+		err = fmt.Errorf("unknown schedule command %q", action)
+	}
+
+	if err != nil {
+		return fmt.Appendf(nil, "error: %v\n", err)
+	}
+	scheduler.wakeUp()
+	return []byte("ok\n")
+}
+
+// this function executes the unread command and returns its response
+func handleUnreadCommand(argument string) []byte {
+	action, value, _ := strings.Cut(argument, " ")
+	var err error
+	switch action {
+	case "":
+		total := 0
+		if err = unread.view(func(counts map[string]UnreadConversation) { total = UnreadTotal(counts) }); err != nil {
+			return fmt.Appendf(nil, "error: %v\n", err)
+		}
+		return fmt.Appendf(nil, "%d\n", total)
+	case "json":
+		var response []byte
+		var marshalErr error
+		err = unread.view(func(counts map[string]UnreadConversation) { response, marshalErr = json.Marshal(counts) })
+		if err = errors.Join(err, marshalErr); err != nil {
+			return fmt.Appendf(nil, "error: %v\n", err)
+		}
+		return append(response, '\n')
+	case "clear":
+		err = MarkRead(value)
+	case "seed":
+		summary := make([]UnreadSummary, 0)
+		if err = json.Unmarshal([]byte(value), &summary); err == nil {
+			err = ApplyUnreadSummary(summary)
+		}
+	default:
+		err = fmt.Errorf("unknown unread command %q", action)
+	}
+
+	if err != nil {
+		return fmt.Appendf(nil, "error: %v\n", err)
 	}
+	return []byte("ok\n")
 }
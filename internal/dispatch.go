@@ -0,0 +1,228 @@
+package internal
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+	"github.com/harshvardha/TerTerChatCLI/internal/protocol"
+)
+
+// what the dispatcher does with an event when its queue is backed up
+type queuePolicy int
+
+const (
+	// never dropped until the queue reaches its hard limit
+	policyKeep queuePolicy = iota
+
+	// dropped once the queue reaches its capacity, used for typing indicators
+	// which are repeated while the user keeps typing
+	policyDrop
+
+	// replaces a frame with the same key still waiting in the queue,
+	// otherwise dropped like policyDrop
+	policyCoalesce
+)
+
+// important events may grow the queue up to this multiple of its capacity
+// before they are dropped as well
+const hardLimitFactor = 4
+
+// delivery and read events are kept because the receipts are saved from them
+var eventPolicies = map[string]queuePolicy{
+	events.EDIT_MESSAGE: policyCoalesce,
+	events.TYPING_START: policyDrop,
+	events.TYPING_STOP:  policyDrop,
+}
+
+// DispatchStats are the counters of the event queue reported by status
+type DispatchStats struct {
+	Workers       int    `json:"workers"`
+	Capacity      int    `json:"capacity"`
+	QueueDepth    int    `json:"queue_depth"`
+	MaxQueueDepth int    `json:"max_queue_depth"`
+	Enqueued      uint64 `json:"enqueued"`
+	Handled       uint64 `json:"handled"`
+	Coalesced     uint64 `json:"coalesced"`
+	Dropped       uint64 `json:"dropped"`
+}
+
+type queuedFrame struct {
+	frame protocol.Frame
+	key   string
+}
+
+// dispatcher hands event frames to a fixed pool of workers so that the
+// goroutine reading from the server never waits on a slow handler
+// frames of the same conversation always go to the same worker so that
+// they are handled in the order they arrived
+type dispatcher struct {
+	mu      sync.Mutex
+	queues  [][]*queuedFrame
+	ready   []*sync.Cond
+	pending map[string]*queuedFrame
+	closed  bool
+	stats   DispatchStats
+	handle  func(protocol.Frame)
+	wg      sync.WaitGroup
+}
+
+var eventQueue *dispatcher
+
+func newDispatcher(workers int, capacity int, handle func(protocol.Frame)) *dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	d := &dispatcher{
+		queues:  make([][]*queuedFrame, workers),
+		ready:   make([]*sync.Cond, workers),
+		pending: make(map[string]*queuedFrame),
+		handle:  handle,
+		stats: DispatchStats{
+			Workers:  workers,
+			Capacity: capacity,
+		},
+	}
+	for index := range d.ready {
+		d.ready[index] = sync.NewCond(&d.mu)
+	}
+
+	return d
+}
+
+func (d *dispatcher) start() {
+	d.wg.Add(len(d.queues))
+	for index := range d.queues {
+		go d.worker(index)
+	}
+}
+
+// this function lets the workers finish the queued frames and waits for them
+func (d *dispatcher) stop() {
+	d.mu.Lock()
+	d.closed = true
+	for _, ready := range d.ready {
+		ready.Broadcast()
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+// this function queues a frame without ever blocking the caller
+func (d *dispatcher) enqueue(frame protocol.Frame) {
+	policy := eventPolicies[frame.Type]
+	key := ""
+	if policy == policyCoalesce {
+		key = coalesceKey(frame)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return
+	}
+	d.stats.Enqueued++
+
+	// replacing the older frame which is still waiting with the newer one
+	if queued, ok := d.pending[key]; ok && len(key) > 0 {
+		queued.frame = frame
+		d.stats.Coalesced++
+		return
+	}
+
+	if d.stats.QueueDepth >= d.stats.Capacity*hardLimitFactor || (policy != policyKeep && d.stats.QueueDepth >= d.stats.Capacity) {
+		d.stats.Dropped++
+		log.Printf("Event queue is full, dropping %s event", frame.Type)
+		return
+	}
+
+	item := &queuedFrame{frame: frame, key: key}
+	if len(key) > 0 {
+		d.pending[key] = item
+	}
+	shard := shardOf(frame, len(d.queues))
+	d.queues[shard] = append(d.queues[shard], item)
+	d.stats.QueueDepth++
+	d.stats.MaxQueueDepth = max(d.stats.MaxQueueDepth, d.stats.QueueDepth)
+	d.ready[shard].Signal()
+}
+
+func (d *dispatcher) worker(index int) {
+	defer d.wg.Done()
+
+	for {
+		d.mu.Lock()
+		for len(d.queues[index]) == 0 && !d.closed {
+			d.ready[index].Wait()
+		}
+		if len(d.queues[index]) == 0 {
+			d.mu.Unlock()
+			return
+		}
+
+		item := d.queues[index][0]
+		d.queues[index][0] = nil
+		d.queues[index] = d.queues[index][1:]
+		if d.pending[item.key] == item {
+			delete(d.pending, item.key)
+		}
+		d.stats.QueueDepth--
+		frame := item.frame
+		d.mu.Unlock()
+
+		d.handle(frame)
+
+		d.mu.Lock()
+		d.stats.Handled++
+		d.mu.Unlock()
+	}
+}
+
+func (d *dispatcher) snapshot() DispatchStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.stats
+}
+
+// routing fields of an event payload
+type eventRoute struct {
+	ID       uuid.UUID `json:"id"`
+	GroupID  uuid.UUID `json:"group_id"`
+	SenderID uuid.UUID `json:"sender_id"`
+}
+
+// this function returns the key under which a frame replaces an older queued one
+func coalesceKey(frame protocol.Frame) string {
+	route := eventRoute{}
+	if err := json.Unmarshal(frame.Payload, &route); err != nil || route.ID == uuid.Nil {
+		return ""
+	}
+
+	return frame.Type + ":" + route.ID.String()
+}
+
+// this function picks the worker for a frame so that every event of a
+// conversation is handled in order, group events are keyed by the group,
+// one to one events by the sender and anything else by the message id
+func shardOf(frame protocol.Frame, workers int) int {
+	route := eventRoute{}
+	json.Unmarshal(frame.Payload, &route)
+
+	key := route.GroupID
+	if key == uuid.Nil {
+		key = route.SenderID
+	}
+	if key == uuid.Nil {
+		key = route.ID
+	}
+	hash := fnv.New32a()
+	hash.Write(key[:])
+
+	return int(hash.Sum32() % uint32(workers))
+}
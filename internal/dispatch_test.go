@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+	"github.com/harshvardha/TerTerChatCLI/internal/protocol"
+)
+
+// this function builds frames of every queue policy spread over a few conversations
+// edits repeat the same message ids so that some of them are coalesced
+func benchmarkFrames(count int) []protocol.Frame {
+	groups := []uuid.UUID{uuid.New(), uuid.New(), uuid.New(), uuid.New()}
+	edited := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	frames := make([]protocol.Frame, 0, count)
+	for index := range count {
+		groupID := groups[index%len(groups)]
+		var name string
+		var payload any
+		switch index % 4 {
+		case 0:
+			name = events.NEW_MESSAGE
+			payload = events.Message{ID: uuid.New(), GroupID: groupID, SenderID: uuid.New(), Description: fmt.Sprintf("message %d", index)}
+		case 1:
+			name = events.EDIT_MESSAGE
+			payload = events.Message{ID: edited[index%len(edited)], GroupID: groupID, SenderID: uuid.New(), Description: fmt.Sprintf("edit %d", index)}
+		case 2:
+			name = events.GROUP_MESSAGE_READ
+			payload = events.GroupMessageRead{ID: uuid.New(), GroupID: groupID, GroupMemberID: uuid.New()}
+		default:
+			name = events.TYPING_START
+			payload = events.Typing{UserID: uuid.New(), GroupID: groupID}
+		}
+		data, _ := json.Marshal(payload)
+		frames = append(frames, protocol.Frame{Type: name, Payload: data})
+	}
+
+	return frames
+}
+
+func BenchmarkDispatcher(b *testing.B) {
+	// dropped frames are logged, which would measure the log instead of the queue
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	frames := benchmarkFrames(1024)
+	d := newDispatcher(4, 256, func(protocol.Frame) {})
+	d.start()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for index := range b.N {
+		d.enqueue(frames[index%len(frames)])
+	}
+	d.stop()
+	b.StopTimer()
+
+	stats := d.snapshot()
+	b.ReportMetric(float64(stats.Coalesced)/float64(b.N), "coalesced/op")
+	b.ReportMetric(float64(stats.Dropped)/float64(b.N), "dropped/op")
+}
+
+func TestDispatcherKeepsReceiptsWhenFull(t *testing.T) {
+	// without workers nothing leaves the queue, so new messages fill it up
+	d := newDispatcher(1, 4, func(protocol.Frame) {})
+	frames := benchmarkFrames(32)
+	for index := 0; index < len(frames); index += 4 {
+		d.enqueue(frames[index])
+	}
+	for _, frame := range frames {
+		if frame.Type == events.GROUP_MESSAGE_READ || frame.Type == events.TYPING_START {
+			d.enqueue(frame)
+		}
+	}
+
+	queued := make(map[string]int)
+	for _, queue := range d.queues {
+		for _, item := range queue {
+			queued[item.frame.Type]++
+		}
+	}
+	if queued[events.NEW_MESSAGE] != 8 || queued[events.GROUP_MESSAGE_READ] != 8 {
+		t.Errorf("queued %d new messages and %d read receipts, want 8 of each", queued[events.NEW_MESSAGE], queued[events.GROUP_MESSAGE_READ])
+	}
+	if queued[events.TYPING_START] != 0 {
+		t.Errorf("queued %d typing frames in a full queue, want 0", queued[events.TYPING_START])
+	}
+}
@@ -0,0 +1,205 @@
+// Package events implements the bus on which the deamon publishes events
+// received from the server. Notifications, the local cache and user hooks
+// subscribe to it independently with Register.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Event is a single event published on the bus
+// Data holds the payload decoded into the type registered for the event name,
+// it is shared between handlers so they must not modify it
+// Replay is set for events published again from the journal, handlers with
+// effects outside the deamon such as notifications or acks, and handlers which
+// change cached files, must ignore them
+type Event struct {
+	Name    string
+	ID      string
+	Payload json.RawMessage
+	Data    any
+	Replay  bool
+}
+
+// Handler handles a published event
+type Handler func(Event) error
+
+// Middleware wraps the delivery of every event to its handlers
+// it can inspect, change or drop the event
+type Middleware func(Handler) Handler
+
+// Bus routes events to the handlers registered for their name
+type Bus struct {
+	mu         sync.RWMutex
+	types      map[string]func() any
+	handlers   map[string][]Handler
+	middleware []Middleware
+	fallback   Handler
+}
+
+// NewBus returns a bus which knows the data types of all server events
+// unknown events are logged until SetDefault replaces the default handler
+func NewBus() *Bus {
+	bus := &Bus{
+		types:    make(map[string]func() any),
+		handlers: make(map[string][]Handler),
+		fallback: logUnknown,
+	}
+	registerBuiltinTypes(bus)
+
+	return bus
+}
+
+// RegisterType sets the type the payload of an event is decoded into
+// newValue must return a pointer to a fresh value on every call
+func (b *Bus) RegisterType(name string, newValue func() any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.types[name] = newValue
+}
+
+// Register subscribes a handler to an event
+// handlers of an event are called in the order they were registered
+func (b *Bus) Register(name string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[name] = append(b.handlers[name], handler)
+}
+
+// Use adds a middleware, the first one added is the outermost
+func (b *Bus) Use(middleware Middleware) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.middleware = append(b.middleware, middleware)
+}
+
+// SetDefault sets the handler for events which have no registered handlers
+func (b *Bus) SetDefault(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.fallback = handler
+}
+
+// On subscribes a handler which receives the typed data of the event
+func On[T any](b *Bus, name string, handler func(Event, *T) error) {
+	b.Register(name, func(event Event) error {
+		data, ok := event.Data.(*T)
+		if !ok {
+			return fmt.Errorf("%s event carries %T instead of %T", name, event.Data, data)
+		}
+		return handler(event, data)
+	})
+}
+
+// Decode decodes the payload of an event into its registered type without publishing it
+// events without a registered type decode to nil
+func (b *Bus) Decode(name string, payload []byte) (any, error) {
+	b.mu.RLock()
+	newValue, typed := b.types[name]
+	b.mu.RUnlock()
+	if !typed {
+		return nil, nil
+	}
+
+	data := newValue()
+	if err := json.Unmarshal(payload, data); err != nil {
+		return nil, fmt.Errorf("error decoding %s event: %w", name, err)
+	}
+	return data, nil
+}
+
+// SkipReplay wraps a handler so that it only handles events received live
+func SkipReplay[T any](handler func(Event, *T) error) func(Event, *T) error {
+	return func(event Event, data *T) error {
+		if event.Replay {
+			return nil
+		}
+		return handler(event, data)
+	}
+}
+
+// Publish decodes the payload and delivers the event to its handlers
+// every handler runs even when an earlier one fails and all errors are returned
+func (b *Bus) Publish(name string, id string, payload []byte) error {
+	return b.publish(Event{Name: name, ID: id, Payload: payload})
+}
+
+// Replay publishes an event again with Replay set
+func (b *Bus) Replay(name string, id string, payload []byte) error {
+	return b.publish(Event{Name: name, ID: id, Payload: payload, Replay: true})
+}
+
+func (b *Bus) publish(event Event) error {
+	data, err := b.Decode(event.Name, event.Payload)
+	if err != nil {
+		return err
+	}
+	event.Data = data
+
+	b.mu.RLock()
+	handlers := b.handlers[event.Name]
+	fallback := b.fallback
+	middleware := b.middleware
+	b.mu.RUnlock()
+
+	deliver := func(event Event) error {
+		if len(handlers) == 0 {
+			return fallback(event)
+		}
+
+		var errs []error
+		for _, handler := range handlers {
+			if err := handler(event); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	// wrapping in reverse so that the first middleware added runs first
+	for index := len(middleware) - 1; index >= 0; index-- {
+		deliver = middleware[index](deliver)
+	}
+
+	return deliver(event)
+}
+
+// this function is the default handler which only logs events nobody handles
+func logUnknown(event Event) error {
+	log.Printf("No handler for %s event, ignoring it", event.Name)
+	return nil
+}
+
+// Logging is a middleware which logs every event and the error of its handlers
+func Logging(logger *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(event Event) error {
+			logger.Printf("Handling %s event %s", event.Name, event.ID)
+			err := next(event)
+			if err != nil {
+				logger.Printf("Error handling %s event: %v", event.Name, err)
+			}
+			return err
+		}
+	}
+}
+
+// Filter is a middleware which drops every event for which keep returns false
+func Filter(keep func(Event) bool) Middleware {
+	return func(next Handler) Handler {
+		return func(event Event) error {
+			if !keep(event) {
+				return nil
+			}
+			return next(event)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package events
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// event names emitted by the server
+const (
+	NEW_MESSAGE            = "NEW_MESSAGE"
+	EDIT_MESSAGE           = "EDIT_MESSAGE"
+	DELETE_MESSAGE         = "DELETE_MESSAGE"
+	MESSAGE_RECEIVED       = "MARK_MESSAGE_RECEIVED"
+	GROUP_MESSAGE_READ     = "GROUP_MESSAGE_READ"
+	MESSAGE_READ           = "MARK_MESSAGE_READ"
+	ADDED_USER_TO_GROUP    = "ADD_USER_TO_GROUP"
+	REMOVE_USER_FROM_GROUP = "REMOVE_USER_FROM_GROUP"
+	MADE_ADMIN             = "MADE_ADMIN"
+	REMOVE_ADMIN           = "REMOVE_ADMIN"
+	TYPING_START           = "TYPING_START"
+	TYPING_STOP            = "TYPING_STOP"
+	ONLINE                 = "ONLINE"
+	OFFLINE                = "OFFLINE"
+	LAST_SEEN              = "LAST_SEEN"
+	REACTION               = "REACTION"
+)
+
+// Message is the data of NEW_MESSAGE and EDIT_MESSAGE events
+type Message struct {
+	ID             uuid.UUID   `json:"id"`
+	GroupID        uuid.UUID   `json:"group_id,omitempty"`
+	SenderID       uuid.UUID   `json:"sender_id"`
+	SenderUsername string      `json:"sender_username,omitempty"`
+	Description    string      `json:"description"`
+	ParentID       uuid.UUID   `json:"parent_id,omitzero"`
+	Attachment     *Attachment `json:"attachment,omitempty"`
+	CreatedAt      string      `json:"created_at,omitempty"`
+	UpdatedAt      string      `json:"updated_at,omitempty"`
+}
+
+// Attachment is a file attached to a message, Checksum is the hex encoded sha256 of its content
+type Attachment struct {
+	ID       uuid.UUID `json:"id"`
+	FileName string    `json:"file_name"`
+	Size     int64     `json:"size"`
+	MimeType string    `json:"mime_type,omitempty"`
+	Checksum string    `json:"checksum"`
+}
+
+// DeleteMessage is the data of DELETE_MESSAGE event
+type DeleteMessage struct {
+	ID       uuid.UUID `json:"id"`
+	SenderID uuid.UUID `json:"sender_id"`
+	GroupID  uuid.UUID `json:"group_id,omitempty"`
+}
+
+// MessageReceived is the data of MARK_MESSAGE_RECEIVED event
+type MessageReceived struct {
+	ID         uuid.UUID `json:"id"`
+	ReceiverID uuid.UUID `json:"receiver_id"`
+}
+
+// MessageRead is the data of MARK_MESSAGE_READ event which tells the sender
+// of a one to one message that its receiver has read it
+type MessageRead struct {
+	ID       uuid.UUID `json:"id"`
+	SenderID uuid.UUID `json:"sender_id"`
+	ReaderID uuid.UUID `json:"reader_id"`
+	ReadAt   string    `json:"read_at,omitempty"`
+}
+
+// GroupMessageRead is the data of GROUP_MESSAGE_READ event
+type GroupMessageRead struct {
+	ID                  uuid.UUID `json:"id"`
+	GroupID             uuid.UUID `json:"group_id"`
+	GroupMemberID       uuid.UUID `json:"group_member_id"`
+	GroupMemberUsername string    `json:"group_member_username"`
+	ReadAt              string    `json:"read_at,omitempty"`
+}
+
+// Typing is the data of TYPING_START and TYPING_STOP events
+// ReceiverID is set in one to one conversations and GroupID in groups
+type Typing struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Username   string    `json:"username,omitempty"`
+	ReceiverID uuid.UUID `json:"receiver_id,omitzero"`
+	GroupID    uuid.UUID `json:"group_id,omitzero"`
+}
+
+// Presence is the data of ONLINE, OFFLINE and LAST_SEEN events
+type Presence struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username,omitempty"`
+	LastSeen string    `json:"last_seen,omitempty"`
+}
+
+// Reaction is the data of REACTION event, Removed is set when the user took
+// the reaction back, ReceiverID is set in one to one conversations and GroupID in groups
+type Reaction struct {
+	MessageID  uuid.UUID `json:"message_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Username   string    `json:"username,omitempty"`
+	ReceiverID uuid.UUID `json:"receiver_id,omitzero"`
+	GroupID    uuid.UUID `json:"group_id,omitzero"`
+	Emoji      string    `json:"emoji"`
+	Removed    bool      `json:"removed,omitempty"`
+	ReactedAt  string    `json:"reacted_at,omitempty"`
+}
+
+// GroupUser is a user taking part in a group event
+type GroupUser struct {
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	Phonenumber string    `json:"phonenumber"`
+}
+
+// DisplayName returns the username or the phonenumber when the username is not known
+func (u GroupUser) DisplayName() string {
+	if len(u.Username) > 0 {
+		return u.Username
+	}
+	return u.Phonenumber
+}
+
+// GroupInfo identifies the group of a group event
+type GroupInfo struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+// GroupEvent is the data of ADD_USER_TO_GROUP, REMOVE_USER_FROM_GROUP,
+// MADE_ADMIN and REMOVE_ADMIN events
+// Actor is the user who made the change and Target the user it was made to
+type GroupEvent struct {
+	Name      string    `json:"name"`
+	Group     GroupInfo `json:"group"`
+	Actor     GroupUser `json:"actor"`
+	Target    GroupUser `json:"target"`
+	EmittedAt string    `json:"emittedAt"`
+}
+
+// UnmarshalJSON also accepts the older payload where "group" held the id of
+// the group along with the username and phonenumber of the affected user
+func (g *GroupEvent) UnmarshalJSON(data []byte) error {
+	type groupEvent GroupEvent
+	if err := json.Unmarshal(data, (*groupEvent)(g)); err != nil {
+		return err
+	}
+
+	if g.Target == (GroupUser{}) {
+		legacy := struct {
+			Group GroupUser `json:"group"`
+		}{}
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return err
+		}
+		g.Target.Username = legacy.Group.Username
+		g.Target.Phonenumber = legacy.Group.Phonenumber
+	}
+
+	return nil
+}
+
+// this function registers the data type of every event emitted by the server
+func registerBuiltinTypes(bus *Bus) {
+	bus.RegisterType(NEW_MESSAGE, func() any { return &Message{} })
+	bus.RegisterType(EDIT_MESSAGE, func() any { return &Message{} })
+	bus.RegisterType(DELETE_MESSAGE, func() any { return &DeleteMessage{} })
+	bus.RegisterType(MESSAGE_RECEIVED, func() any { return &MessageReceived{} })
+	bus.RegisterType(GROUP_MESSAGE_READ, func() any { return &GroupMessageRead{} })
+	bus.RegisterType(MESSAGE_READ, func() any { return &MessageRead{} })
+	bus.RegisterType(TYPING_START, func() any { return &Typing{} })
+	bus.RegisterType(TYPING_STOP, func() any { return &Typing{} })
+	bus.RegisterType(REACTION, func() any { return &Reaction{} })
+	for _, name := range []string{ONLINE, OFFLINE, LAST_SEEN} {
+		bus.RegisterType(name, func() any { return &Presence{} })
+	}
+	for _, name := range []string{ADDED_USER_TO_GROUP, REMOVE_USER_FROM_GROUP, MADE_ADMIN, REMOVE_ADMIN} {
+		bus.RegisterType(name, func() any { return &GroupEvent{} })
+	}
+}
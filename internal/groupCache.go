@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+// this function finds the name of a group in group.json written by conversation --list
+// which holds every group of the user, including the ones they were added to
+func cachedGroupName(groupID uuid.UUID) string {
+	groups := make(map[int]utility.GroupConversation)
+	if err := utility.ReadJSONFile("group.json", &groups); err != nil {
+		return ""
+	}
+	for _, group := range groups {
+		if group.GroupID.UUID == groupID {
+			return group.GroupName
+		}
+	}
+
+	return ""
+}
+
+// this function returns the path of the members cache written by group --members
+func groupMembersFile(groupID uuid.UUID) string {
+	return fmt.Sprintf("%s_members.json", groupID.String())
+}
+
+// this function keeps <groupID>_members.json in sync with membership events
+// the cache is only updated when it exists, a missing cache is filled
+// completely the next time group --members is run
+func updateGroupMembers(event events.Event, groupEvent *events.GroupEvent) error {
+	if groupEvent.Group.ID == uuid.Nil {
+		return nil
+	}
+	membersFile := groupMembersFile(groupEvent.Group.ID)
+
+	// the members of a group we have been removed from are not ours to keep
+	if event.Name == events.REMOVE_USER_FROM_GROUP && isCurrentUser(groupEvent.Target) {
+		if err := os.Remove(membersFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	membersMap := make(map[int]utility.GroupMember)
+	if err := utility.ReadJSONFile(membersFile, &membersMap); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	// the cache is keyed by the index shown to user so it is kept as an ordered list
+	indexes := make([]int, 0, len(membersMap))
+	for index := range membersMap {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	members := make([]utility.GroupMember, 0, len(indexes))
+	for _, index := range indexes {
+		members = append(members, membersMap[index])
+	}
+
+	position := -1
+	for index, member := range members {
+		if isSameMember(member, groupEvent.Target) {
+			position = index
+			break
+		}
+	}
+
+	switch event.Name {
+	case events.ADDED_USER_TO_GROUP:
+		if position != -1 {
+			return nil
+		}
+		members = append(members, utility.GroupMember{
+			ID:       groupEvent.Target.ID,
+			Username: groupEvent.Target.DisplayName(),
+		})
+	case events.REMOVE_USER_FROM_GROUP:
+		if position == -1 {
+			return nil
+		}
+		members = append(members[:position], members[position+1:]...)
+	default:
+		return nil
+	}
+
+	membersMap = make(map[int]utility.GroupMember, len(members))
+	for index, member := range members {
+		membersMap[index] = member
+	}
+	return utility.WriteJSONFile(membersFile, membersMap)
+}
+
+// this function matches a cached member with the user of an event
+// by id when the event carries one and by username otherwise
+func isSameMember(member utility.GroupMember, user events.GroupUser) bool {
+	if user.ID != uuid.Nil {
+		return member.ID == user.ID
+	}
+	return len(user.Username) > 0 && member.Username == user.Username
+}
+
+// this function reports whether the user of an event is the logged in user
+func isCurrentUser(user events.GroupUser) bool {
+	self, err := currentUser()
+	if err != nil || self.UserID == uuid.Nil {
+		return false
+	}
+	return user.ID == self.UserID
+}
+
+// this function builds a sentence like "Alice added Bob to Team Infra"
+func groupEventText(name string, groupEvent *events.GroupEvent) string {
+	actor := groupEvent.Actor.DisplayName()
+	if len(actor) == 0 {
+		actor = "Someone"
+	} else if isCurrentUser(groupEvent.Actor) {
+		actor = "You"
+	}
+	target := groupEvent.Target.DisplayName()
+	if len(target) == 0 {
+		target = "a member"
+	} else if isCurrentUser(groupEvent.Target) {
+		target = "you"
+	}
+	groupName := groupEvent.Group.Name
+	if len(groupName) == 0 {
+		groupName = cachedGroupName(groupEvent.Group.ID)
+	}
+	if len(groupName) == 0 {
+		groupName = "a group"
+	}
+
+	switch name {
+	case events.ADDED_USER_TO_GROUP:
+		return fmt.Sprintf("%s added %s to %s", actor, target, groupName)
+	case events.REMOVE_USER_FROM_GROUP:
+		if groupEvent.Actor.ID != uuid.Nil && groupEvent.Actor.ID == groupEvent.Target.ID {
+			return fmt.Sprintf("%s left %s", actor, groupName)
+		}
+		return fmt.Sprintf("%s removed %s from %s", actor, target, groupName)
+	case events.MADE_ADMIN:
+		return fmt.Sprintf("%s made %s an admin of %s", actor, target, groupName)
+	case events.REMOVE_ADMIN:
+		return fmt.Sprintf("%s removed %s as admin of %s", actor, target, groupName)
+	default:
+		return fmt.Sprintf("%s changed %s", actor, groupName)
+	}
+}
+
+// this function subscribes the members cache to membership events
+// replayed events would bring back members who were removed since
+func registerGroupCache(bus *events.Bus) {
+	events.On(bus, events.ADDED_USER_TO_GROUP, events.SkipReplay(updateGroupMembers))
+	events.On(bus, events.REMOVE_USER_FROM_GROUP, events.SkipReplay(updateGroupMembers))
+}
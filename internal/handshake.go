@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/harshvardha/TerTerChatCLI/internal/protocol"
+)
+
+const (
+	// ClientVersion is sent to the server in the hello frame
+	ClientVersion = "0.1.0"
+
+	// TokenFile holds the jwt received on login
+	TokenFile = "token.auth"
+
+	helloFrameType    = "HELLO"
+	helloAccepted     = "accepted"
+	helloRejected     = "rejected"
+	handshakeTimeout  = 10 * time.Second
+	maxHelloReplySize = 64 * 1024
+)
+
+// features supported by this client, announced to the server in the hello frame
+var clientCapabilities = []string{"events", "heartbeat", "resume", protocol.Capability}
+
+// ErrHandshakeRejected is returned when the server refuses the hello frame
+var ErrHandshakeRejected = errors.New("handshake rejected")
+
+// first frame written on the socket to identify and authenticate this session
+type helloFrame struct {
+	Type          string        `json:"type"`
+	Token         string        `json:"token"`
+	ClientVersion string        `json:"client_version"`
+	Capabilities  []string      `json:"capabilities"`
+	ResumeCursor  *resumeCursor `json:"resume_cursor,omitempty"`
+}
+
+// reply of the server to the hello frame
+type helloResult struct {
+	Status       string   `json:"status"`
+	Reason       string   `json:"reason,omitempty"`
+	SessionID    string   `json:"session_id,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// set when the server will replay the events emitted after the resume cursor
+	Replay bool `json:"replay,omitempty"`
+}
+
+// this function reads the jwt stored by user --connect
+func readAuthToken() (string, error) {
+	token, err := os.ReadFile(TokenFile)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
+
+// this function sends the hello frame and waits for the server to accept or reject it
+// the reader must be the same one used afterwards to read events so that no
+// buffered bytes are lost
+func handshake(connection net.Conn, reader *bufio.Reader, resume *resumeCursor) (helloResult, error) {
+	result := helloResult{}
+	token, err := readAuthToken()
+	if err != nil {
+		return result, fmt.Errorf("error reading auth token, please login again: %w", err)
+	}
+
+	hello, err := json.Marshal(helloFrame{
+		Type:          helloFrameType,
+		Token:         token,
+		ClientVersion: ClientVersion,
+		Capabilities:  clientCapabilities,
+		ResumeCursor:  resume,
+	})
+	if err != nil {
+		return result, err
+	}
+
+	connection.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer connection.SetDeadline(time.Time{})
+
+	if _, err = connection.Write(append(hello, '\n')); err != nil {
+		return result, fmt.Errorf("error sending hello frame: %w", err)
+	}
+
+	reply, err := reader.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return result, fmt.Errorf("hello reply is larger than %d bytes", maxHelloReplySize)
+		}
+		return result, fmt.Errorf("error reading hello reply: %w", err)
+	}
+	if err = json.Unmarshal(reply, &result); err != nil {
+		return result, fmt.Errorf("malformed hello reply: %w", err)
+	}
+
+	switch result.Status {
+	case helloAccepted:
+		return result, nil
+	case helloRejected:
+		if len(result.Reason) == 0 {
+			result.Reason = "no reason given"
+		}
+		return result, fmt.Errorf("%w: %s", ErrHandshakeRejected, result.Reason)
+	default:
+		return result, fmt.Errorf("unknown hello reply status %q", result.Status)
+	}
+}
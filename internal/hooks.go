@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+)
+
+// hooks registered under this name run for every event
+const allEventsHook = "*"
+
+// longest output of a hook kept for the log
+const maxHookOutput = 4096
+
+// HookResult is the outcome of running a hook once
+type HookResult struct {
+	Command  string
+	ExitCode int
+	Output   string
+	Duration time.Duration
+	Err      error
+}
+
+// hookInput is written to the stdin of a hook
+type hookInput struct {
+	Event   string          `json:"event"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+	DryRun  bool            `json:"dry_run,omitempty"`
+}
+
+// hookRunner runs the hooks of published events in the background
+// at most MaxConcurrent hooks run at once and further events wait in line
+// until MaxQueued are waiting, after that the hooks of new events are skipped
+type hookRunner struct {
+	config HooksConfig
+	slots  chan struct{}
+	queued atomic.Int64
+	wg     sync.WaitGroup
+}
+
+// hooks is replaced with the configured runner when the deamon starts
+var hooks = newHookRunner(defaultConfig().Hooks)
+
+func newHookRunner(config HooksConfig) *hookRunner {
+	return &hookRunner{
+		config: config,
+		slots:  make(chan struct{}, config.MaxConcurrent),
+	}
+}
+
+// this function returns the hooks configured for an event
+func (r *hookRunner) hooksFor(name string) []HookConfig {
+	configured := make([]HookConfig, 0)
+	configured = append(configured, r.config.Events[name]...)
+	configured = append(configured, r.config.Events[allEventsHook]...)
+
+	return configured
+}
+
+// this function starts the hooks of an event without waiting for them
+func (r *hookRunner) dispatch(event events.Event) {
+	for _, hook := range r.hooksFor(event.Name) {
+		limit := int64(r.config.MaxConcurrent + r.config.MaxQueued)
+		if r.queued.Add(1) > limit {
+			r.queued.Add(-1)
+			log.Printf("Skipping hook %s for %s event, %d hooks already running or waiting", hook.Command, event.Name, limit)
+			continue
+		}
+
+		r.wg.Add(1)
+		go func(hook HookConfig) {
+			defer r.wg.Done()
+			defer r.queued.Add(-1)
+
+			r.slots <- struct{}{}
+			defer func() { <-r.slots }()
+
+			result := r.run(hook, event.Name, event.ID, event.Payload, false)
+			if result.Err != nil {
+				log.Printf("Hook %s for %s event failed after %s: %v %s", hook.Command, event.Name, result.Duration, result.Err, result.Output)
+			}
+		}(hook)
+	}
+}
+
+// this function waits for running hooks, used when the deamon shuts down
+func (r *hookRunner) wait() {
+	r.wg.Wait()
+}
+
+// this function runs a single hook and waits for it to exit or time out
+func (r *hookRunner) run(hook HookConfig, name string, id string, payload []byte, dryRun bool) HookResult {
+	result := HookResult{Command: hook.Command, ExitCode: -1}
+	input, err := json.Marshal(hookInput{Event: name, ID: id, Payload: payload, DryRun: dryRun})
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	timeout := time.Duration(r.config.Timeout)
+	if hook.Timeout > 0 {
+		timeout = time.Duration(hook.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	command := exec.CommandContext(ctx, hook.Command, hook.Args...)
+	command.Stdin = bytes.NewReader(input)
+	command.Env = append(os.Environ(), hookEnvironment(name, id, payload, dryRun)...)
+
+	// a hook which leaves children holding its output open must not block us past the timeout
+	command.WaitDelay = time.Second
+
+	started := time.Now()
+	output, err := command.CombinedOutput()
+	result.Duration = time.Since(started)
+	result.Output = strings.TrimSpace(string(output))
+	if len(result.Output) > maxHookOutput {
+		result.Output = result.Output[:maxHookOutput] + "..."
+	}
+	if command.ProcessState != nil {
+		result.ExitCode = command.ProcessState.ExitCode()
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		result.Err = fmt.Errorf("timed out after %s", timeout)
+	} else {
+		result.Err = err
+	}
+
+	return result
+}
+
+// this function builds the environment variables describing an event to a hook
+func hookEnvironment(name string, id string, payload []byte, dryRun bool) []string {
+	fields := struct {
+		ID             string          `json:"id"`
+		SenderID       string          `json:"sender_id"`
+		SenderUsername string          `json:"sender_username"`
+		GroupID        string          `json:"group_id"`
+		Group          json.RawMessage `json:"group"`
+	}{}
+	json.Unmarshal(payload, &fields)
+
+	// group events carry the group as an object
+	if len(fields.GroupID) == 0 && len(fields.Group) > 0 {
+		group := struct {
+			ID string `json:"id"`
+		}{}
+		if json.Unmarshal(fields.Group, &group) == nil {
+			fields.GroupID = group.ID
+		}
+	}
+
+	dryRunValue := "0"
+	if dryRun {
+		dryRunValue = "1"
+	}
+
+	return []string{
+		"TERTER_EVENT=" + name,
+		"TERTER_EVENT_ID=" + id,
+		"TERTER_MESSAGE_ID=" + fields.ID,
+		"TERTER_SENDER_ID=" + fields.SenderID,
+		"TERTER_SENDER_USERNAME=" + fields.SenderUsername,
+		"TERTER_GROUP_ID=" + fields.GroupID,
+		"TERTER_DRY_RUN=" + dryRunValue,
+	}
+}
+
+// middleware which hands every event received live to the user hooks before its handlers
+func runHooks(next events.Handler) events.Handler {
+	return func(event events.Event) error {
+		if !event.Replay {
+			hooks.dispatch(event)
+		}
+		return next(event)
+	}
+}
+
+// TestHooks runs the hooks configured for an event one after another and
+// returns their results, the hooks see TERTER_DRY_RUN=1
+func TestHooks(config HooksConfig, name string, payload []byte) []HookResult {
+	runner := newHookRunner(config)
+	results := make([]HookResult, 0)
+	for _, hook := range runner.hooksFor(name) {
+		results = append(results, runner.run(hook, name, "", payload, true))
+	}
+
+	return results
+}
+
+// SampleEventPayload returns a made up payload of an event for dry runs of hooks
+func SampleEventPayload(name string) ([]byte, error) {
+	self, _ := currentUser()
+	teammate := events.GroupUser{ID: uuid.New(), Username: "teammate"}
+	groupID := uuid.New()
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var sample any
+	switch name {
+	case events.NEW_MESSAGE, events.EDIT_MESSAGE:
+		sample = events.Message{
+			ID:             uuid.New(),
+			SenderID:       teammate.ID,
+			SenderUsername: teammate.Username,
+			Description:    "test message from TerTer hooks test",
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+	case events.DELETE_MESSAGE:
+		sample = events.DeleteMessage{ID: uuid.New(), SenderID: teammate.ID}
+	case events.MESSAGE_RECEIVED:
+		sample = events.MessageReceived{ID: uuid.New(), ReceiverID: teammate.ID}
+	case events.MESSAGE_READ:
+		sample = events.MessageRead{ID: uuid.New(), SenderID: self.UserID, ReaderID: teammate.ID, ReadAt: now}
+	case events.GROUP_MESSAGE_READ:
+		sample = events.GroupMessageRead{
+			ID:                  uuid.New(),
+			GroupID:             groupID,
+			GroupMemberID:       teammate.ID,
+			GroupMemberUsername: teammate.Username,
+		}
+	case events.ADDED_USER_TO_GROUP, events.REMOVE_USER_FROM_GROUP, events.MADE_ADMIN, events.REMOVE_ADMIN:
+		sample = events.GroupEvent{
+			Name:      name,
+			Group:     events.GroupInfo{ID: groupID, Name: "Test group"},
+			Actor:     teammate,
+			Target:    events.GroupUser{ID: self.UserID, Username: self.Username},
+			EmittedAt: now,
+		}
+	case events.TYPING_START, events.TYPING_STOP:
+		sample = events.Typing{UserID: teammate.ID, Username: teammate.Username, ReceiverID: self.UserID}
+	case events.ONLINE, events.OFFLINE, events.LAST_SEEN:
+		sample = events.Presence{UserID: teammate.ID, Username: teammate.Username, LastSeen: now}
+	case events.REACTION:
+		sample = events.Reaction{MessageID: uuid.New(), UserID: teammate.ID, Username: teammate.Username, ReceiverID: self.UserID, Emoji: "👍", ReactedAt: now}
+	default:
+		return nil, fmt.Errorf("no sample payload for %s event, pass one with --payload", name)
+	}
+
+	return json.Marshal(sample)
+}
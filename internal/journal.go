@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/harshvardha/TerTerChatCLI/internal/protocol"
+)
+
+// sources of journal entries
+const (
+	SourceServer  = "server"
+	SourceCatchUp = "catch-up"
+	SourceReplay  = "replay"
+)
+
+// JournalEntry is a single line of the event journal
+// Raw holds the frame exactly as it was read and Parsed the payload after
+// decoding it into the type of its event, Error is set when either failed
+type JournalEntry struct {
+	ReceivedAt time.Time       `json:"received_at"`
+	Source     string          `json:"source"`
+	Type       string          `json:"type,omitempty"`
+	ID         string          `json:"id,omitempty"`
+	Version    int             `json:"version"`
+	Raw        string          `json:"raw,omitempty"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	Parsed     json.RawMessage `json:"parsed,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// frames waiting to be journaled, the reader drops frames from the journal
+// rather than waiting when the writer falls this far behind
+const journalQueueSize = 1024
+
+// journalRecord is a frame waiting to be encoded and written by the journal writer
+type journalRecord struct {
+	receivedAt time.Time
+	source     string
+	frame      protocol.Frame
+	raw        []byte
+	err        error
+}
+
+// eventJournal appends an entry for every frame received to a rotating file
+// frames are decoded and written by a goroutine of its own so that the
+// goroutine reading from the server only copies them
+type eventJournal struct {
+	mu      sync.Mutex
+	writer  *rotatingWriter
+	records chan journalRecord
+	done    chan struct{}
+}
+
+// journal is opened when the deamon starts, frames are not journaled before that
+var journal = &eventJournal{}
+
+// this function opens the journal file described by the journal config
+// and starts the goroutine writing to it
+func (j *eventJournal) open(config JournalConfig) error {
+	writer, err := newRotatingWriter(config.File, config.MaxSizeMB*1024*1024, 0, config.MaxBackups)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.writer = writer
+	j.records = make(chan journalRecord, journalQueueSize)
+	j.done = make(chan struct{})
+	go j.write(j.records, j.done)
+	j.mu.Unlock()
+	return nil
+}
+
+// this function writes the frames still queued and closes the journal file
+func (j *eventJournal) close() {
+	j.mu.Lock()
+	records, done := j.records, j.done
+	j.records = nil
+	j.mu.Unlock()
+	if records == nil {
+		return
+	}
+
+	close(records)
+	<-done
+	j.writer.Close()
+	j.writer = nil
+}
+
+// this function queues a frame to be journaled along with its decoded payload
+// raw is the frame as read from its source and err the error reading it
+func (j *eventJournal) record(source string, frame protocol.Frame, raw []byte, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.records == nil {
+		return
+	}
+
+	// raw is only valid until the next frame is read
+	record := journalRecord{
+		receivedAt: time.Now().UTC(),
+		source:     source,
+		frame:      frame,
+		raw:        append([]byte(nil), raw...),
+		err:        err,
+	}
+	select {
+	case j.records <- record:
+	default:
+		log.Printf("Event journal is behind, not journaling %s event", frame.Type)
+	}
+}
+
+// this function encodes and writes the queued frames until records is closed
+func (j *eventJournal) write(records <-chan journalRecord, done chan<- struct{}) {
+	defer close(done)
+
+	for record := range records {
+		line, err := journalLine(record)
+		if err != nil {
+			log.Printf("Error encoding journal entry: %v", err)
+			continue
+		}
+		if _, err = j.writer.Write(line); err != nil {
+			log.Printf("Error writing to event journal: %v", err)
+		}
+	}
+}
+
+// this function encodes a frame as a line of the journal
+func journalLine(record journalRecord) ([]byte, error) {
+	frame, err := record.frame, record.err
+	entry := JournalEntry{
+		ReceivedAt: record.receivedAt,
+		Source:     record.source,
+		Type:       frame.Type,
+		ID:         frame.ID,
+		Version:    frame.Version,
+		Raw:        string(record.raw),
+		Payload:    frame.Payload,
+	}
+	if err == nil && len(frame.Type) > 0 {
+		var data any
+		if data, err = bus.Decode(frame.Type, frame.Payload); err == nil && data != nil {
+			entry.Parsed, err = json.Marshal(data)
+		}
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	// a payload which is not valid json can not be embedded, raw keeps it
+	if len(entry.Payload) > 0 && !json.Valid(entry.Payload) {
+		entry.Payload = nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// ReadJournal calls visit for every entry of the journal and its rotated files
+// from oldest to newest, returning early with the first error of visit
+func ReadJournal(config JournalConfig, visit func(JournalEntry) error) error {
+	files := append(rotatedFiles(config.File), config.File)
+	for _, file := range files {
+		err := ReadJournalFile(file, visit)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadJournalFile calls visit for every entry of a single journal file
+func ReadJournalFile(path string, visit func(JournalEntry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// an entry holds a frame up to MaxFrameSize several times over
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*protocol.MaxFrameSize)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		entry := JournalEntry{}
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		if err = visit(entry); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// this function publishes the frames recorded in a journal file on the bus again
+// the frames skip deduplication and do not move the event cursor so that the
+// same file can be replayed as often as needed while reproducing a bug, they
+// are published as replays so that they neither leave the deamon nor change its caches
+func replayJournal(path string) (int, int, error) {
+	replayed, failed := 0, 0
+	err := ReadJournalFile(path, func(entry JournalEntry) error {
+		if len(entry.Type) == 0 || len(entry.Payload) == 0 || entry.Type == protocol.TypePing || entry.Type == protocol.TypePong {
+			return nil
+		}
+
+		frame := protocol.Frame{Type: entry.Type, ID: entry.ID, Version: entry.Version, Payload: entry.Payload}
+		journal.record(SourceReplay, frame, []byte(entry.Raw), nil)
+		if err := bus.Replay(frame.Type, frame.ID, frame.Payload); err != nil {
+			log.Printf("Error replaying %s event: %v", frame.Type, err)
+			failed++
+		}
+		replayed++
+		return nil
+	})
+
+	return replayed, failed, err
+}
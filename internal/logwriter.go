@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatingWriter is an io.Writer over a file which is rotated once it grows
+// beyond maxSize bytes or once it has been written to for longer than maxAge
+// rotated files are renamed to <name>-<timestamp><ext> and only the newest
+// maxBackups of them are kept
+type rotatingWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+	openedAt   time.Time
+}
+
+func newRotatingWriter(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingWriter, error) {
+	writer := &rotatingWriter{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	if err := writer.open(); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// OpenDeamonLog opens the log file of deamon process described by the log config
+func OpenDeamonLog(config LogConfig) (io.WriteCloser, error) {
+	return newRotatingWriter(config.File, config.MaxSizeMB*1024*1024, time.Duration(config.MaxAge), config.MaxBackups)
+}
+
+func (w *rotatingWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = w.startedAt(info.Size() == 0)
+	return nil
+}
+
+// this function returns the time the current file was started
+// the time is kept in <path>.started so that an existing file keeps its age
+// across restarts of the deamon, a new file or one whose start time is
+// unknown is started now
+func (w *rotatingWriter) startedAt(newFile bool) time.Time {
+	startedPath := w.path + ".started"
+	if !newFile {
+		if data, err := os.ReadFile(startedPath); err == nil {
+			if startedAt, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data))); err == nil {
+				return startedAt
+			}
+		}
+	}
+
+	now := time.Now()
+	// without the file the age is only lost on the next restart
+	os.WriteFile(startedPath, []byte(now.Format(time.RFC3339Nano)), 0600)
+	return now
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tooLarge := w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize
+	tooOld := w.maxAge > 0 && time.Since(w.openedAt) > w.maxAge
+	if tooLarge || tooOld {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
+
+// this function renames the current file with a timestamp suffix,
+// opens a fresh file at the same path and removes old backups
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	extension := filepath.Ext(w.path)
+	backupPath := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(w.path, extension), time.Now().Format("20060102T150405.000"), extension)
+	if err := os.Rename(w.path, backupPath); err != nil {
+		return err
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.removeOldBackups()
+	return nil
+}
+
+// this function keeps only the newest maxBackups rotated files
+func (w *rotatingWriter) removeOldBackups() {
+	if w.maxBackups <= 0 {
+		return
+	}
+
+	backups := rotatedFiles(w.path)
+	if len(backups) <= w.maxBackups {
+		return
+	}
+	for _, backup := range backups[:len(backups)-w.maxBackups] {
+		os.Remove(backup)
+	}
+}
+
+// this function lists the rotated files of the given path from oldest to newest
+// the timestamp suffix makes lexical order the same as chronological order
+func rotatedFiles(path string) []string {
+	extension := filepath.Ext(path)
+	backups, err := filepath.Glob(fmt.Sprintf("%s-*%s", strings.TrimSuffix(path, extension), extension))
+	if err != nil {
+		return nil
+	}
+	sort.Strings(backups)
+
+	return backups
+}
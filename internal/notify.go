@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-toast/toast"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+// this function shows a desktop notification
+func pushNotification(title string, message string) error {
+	notification := toast.Notification{
+		AppID:   "TerTerChat",
+		Title:   title,
+		Message: message,
+	}
+	return notification.Push()
+}
+
+// this function passes a new or edited message on to the coalescer
+// unless the notification rules hold it back
+func notifyMessage(event events.Event, message *events.Message) error {
+	decision := evaluateRules(event.Name, message, time.Now())
+	if !decision.notify {
+		log.Printf("notification for %s %s suppressed: %s", event.Name, message.ID, decision.reason)
+		return nil
+	}
+
+	if message.Attachment != nil {
+		shown := *message
+		shown.Description = attachmentText(message)
+		message = &shown
+	}
+	notifications.submit(event.Name, message, decision.highlight)
+	return nil
+}
+
+// this function describes a message with a file attached for notifications,
+// e.g. "📎 deamon.log (1.2 MB)" followed by the caption when there is one
+func attachmentText(message *events.Message) string {
+	text := fmt.Sprintf("📎 %s (%s)", message.Attachment.FileName, utility.FormatSize(message.Attachment.Size))
+	if len(message.Description) > 0 {
+		text = fmt.Sprintf("%s %s", text, message.Description)
+	}
+	return text
+}
+
+// this function subscribes the desktop notifications to server events
+func registerNotifications(bus *events.Bus) {
+	// show notification for new or edited message
+	// replayed events are not shown again
+	events.On(bus, events.NEW_MESSAGE, events.SkipReplay(notifyMessage))
+	events.On(bus, events.EDIT_MESSAGE, events.SkipReplay(notifyMessage))
+	events.On(bus, events.DELETE_MESSAGE, events.SkipReplay(func(event events.Event, message *events.DeleteMessage) error {
+		return pushNotification("message deleted", message.ID.String())
+	}))
+	events.On(bus, events.MESSAGE_RECEIVED, events.SkipReplay(func(event events.Event, message *events.MessageReceived) error {
+		return pushNotification("message received", message.ID.String())
+	}))
+	events.On(bus, events.GROUP_MESSAGE_READ, events.SkipReplay(func(event events.Event, message *events.GroupMessageRead) error {
+		return pushNotification("group message read", message.ID.String())
+	}))
+	// group notifications read like "Alice added Bob to Team Infra"
+	for _, name := range []string{events.ADDED_USER_TO_GROUP, events.REMOVE_USER_FROM_GROUP, events.MADE_ADMIN, events.REMOVE_ADMIN} {
+		events.On(bus, name, events.SkipReplay(func(event events.Event, message *events.GroupEvent) error {
+			return pushNotification("group update", groupEventText(event.Name, message))
+		}))
+	}
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+)
+
+// a typing indicator disappears after this long without TYPING_START being
+// repeated, in case the TYPING_STOP event is lost
+const typingTimeout = 10 * time.Second
+
+// PresenceEntry is what the deamon knows about another user
+type PresenceEntry struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"last_seen,omitzero"`
+
+	// conversation the user is typing in, our own id is never used so it is
+	// the user id for one to one conversations and the group id for groups
+	TypingIn    uuid.UUID `json:"typing_in,omitzero"`
+	TypingUntil time.Time `json:"typing_until,omitzero"`
+}
+
+// IsTypingIn reports whether the user is typing in the given conversation
+func (p PresenceEntry) IsTypingIn(conversationID uuid.UUID, now time.Time) bool {
+	return p.TypingIn == conversationID && now.Before(p.TypingUntil)
+}
+
+// presenceTable keeps the presence of users in memory, it is rebuilt from
+// events after every restart of the deamon
+type presenceTable struct {
+	mu    sync.Mutex
+	users map[uuid.UUID]*PresenceEntry
+}
+
+var presence = &presenceTable{users: make(map[uuid.UUID]*PresenceEntry)}
+
+// this function returns the entry of a user, creating it on first sight
+// the caller holds the lock
+func (t *presenceTable) entry(userID uuid.UUID, username string) *PresenceEntry {
+	user, ok := t.users[userID]
+	if !ok {
+		user = &PresenceEntry{UserID: userID}
+		t.users[userID] = user
+	}
+	if len(username) > 0 {
+		user.Username = username
+	}
+
+	return user
+}
+
+// this function records a typing event
+func (t *presenceTable) recordTyping(event events.Event, typing *events.Typing) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	user := t.entry(typing.UserID, typing.Username)
+	if event.Name == events.TYPING_STOP {
+		user.TypingIn = uuid.Nil
+		user.TypingUntil = time.Time{}
+		return nil
+	}
+
+	// typing is proof of being online
+	user.Online = true
+	user.TypingIn = typing.UserID
+	if typing.GroupID != uuid.Nil {
+		user.TypingIn = typing.GroupID
+	}
+	user.TypingUntil = time.Now().Add(typingTimeout)
+	return nil
+}
+
+// this function records an online, offline or last seen event
+func (t *presenceTable) recordPresence(event events.Event, update *events.Presence) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	user := t.entry(update.UserID, update.Username)
+
+	switch event.Name {
+	case events.ONLINE:
+		user.Online = true
+	case events.OFFLINE:
+		user.Online = false
+		user.TypingIn = uuid.Nil
+		user.TypingUntil = time.Time{}
+	}
+	// an event without a valid timestamp leaves the last seen time as it was
+	if lastSeen, err := time.Parse(time.RFC3339Nano, update.LastSeen); err == nil && lastSeen.After(user.LastSeen) {
+		user.LastSeen = lastSeen
+	}
+	return nil
+}
+
+// this function returns a copy of the table sorted by username
+// typing indicators which have run out are left out
+func (t *presenceTable) snapshot() []PresenceEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	entries := make([]PresenceEntry, 0, len(t.users))
+	for _, user := range t.users {
+		entry := *user
+		if !now.Before(entry.TypingUntil) {
+			entry.TypingIn = uuid.Nil
+			entry.TypingUntil = time.Time{}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Username < entries[j].Username })
+
+	return entries
+}
+
+// TypingRequest is sent to the deamon by a composer to emit typing events
+type TypingRequest struct {
+	ReceiverID uuid.UUID `json:"receiver_id,omitzero"`
+	GroupID    uuid.UUID `json:"group_id,omitzero"`
+}
+
+// this function tells the other side of a conversation that we started or stopped typing
+func sendTyping(start bool, receiverID uuid.UUID, groupID uuid.UUID) error {
+	self, err := currentUser()
+	if err != nil {
+		return err
+	}
+
+	name := events.TYPING_STOP
+	if start {
+		name = events.TYPING_START
+	}
+	return sendToServer(name, events.Typing{
+		UserID:     self.UserID,
+		Username:   self.Username,
+		ReceiverID: receiverID,
+		GroupID:    groupID,
+	})
+}
+
+// this function subscribes the presence table to typing and presence events
+func registerPresence(bus *events.Bus) {
+	events.On(bus, events.TYPING_START, presence.recordTyping)
+	events.On(bus, events.TYPING_STOP, presence.recordTyping)
+	for _, name := range []string{events.ONLINE, events.OFFLINE, events.LAST_SEEN} {
+		events.On(bus, name, presence.recordPresence)
+	}
+}
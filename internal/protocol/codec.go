@@ -0,0 +1,277 @@
+// Package protocol implements the wire format used on the event socket
+// between the deamon and the server.
+//
+// A frame is written as a 6 byte header followed by a json envelope:
+//
+//	magic (1 byte) | version (1 byte) | length of envelope (4 bytes, big endian) | envelope
+//
+// During rollout the decoder also accepts the older text format where every
+// event is a "NAME|json\n" line and heartbeats are "_PING_\n" and "_PONG_\n".
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// Version is the newest frame version understood by this package
+	Version = 1
+
+	// Capability is announced in the hello frame by clients which can speak framed protocol
+	Capability = "framing-v1"
+
+	// MaxFrameSize is the largest envelope accepted by the decoder
+	MaxFrameSize = 1 << 20
+
+	// frame types used for heartbeats
+	TypePing = "PING"
+	TypePong = "PONG"
+
+	// first byte of every framed message, it can never start a text line
+	// because event names and heartbeats are plain ascii
+	magic      byte = 0xC5
+	headerSize      = 6
+
+	legacyPing      = "_PING_"
+	legacyPong      = "_PONG_"
+	legacySeparator = '|'
+)
+
+var (
+	ErrFrameTooLarge      = errors.New("frame exceeds maximum size")
+	ErrMalformedFrame     = errors.New("malformed frame")
+	ErrUnsupportedVersion = errors.New("unsupported frame version")
+)
+
+// Frame is a single message exchanged on the event socket
+// frames decoded from the text format have version 0 and no id
+type Frame struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Version int             `json:"version"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Decoder reads frames in either the framed or the text format
+type Decoder struct {
+	reader *bufio.Reader
+	raw    []byte
+}
+
+// NewDecoder returns a decoder reading from r
+// passing a *bufio.Reader makes the decoder reuse it along with anything it has buffered
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{reader: bufio.NewReader(r)}
+}
+
+// Decode reads the next frame, detecting its format from the first byte
+func (d *Decoder) Decode() (Frame, error) {
+	d.raw = nil
+	first, err := d.reader.Peek(1)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	if first[0] == magic {
+		return d.decodeFramed()
+	}
+	return d.decodeLegacy()
+}
+
+func (d *Decoder) decodeFramed() (Frame, error) {
+	frame := Frame{}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(d.reader, header); err != nil {
+		return frame, unexpectedEOF(err)
+	}
+
+	version := int(header[1])
+	if version == 0 || version > Version {
+		return frame, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	length := binary.BigEndian.Uint32(header[2:])
+	if length > MaxFrameSize {
+		return frame, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, length)
+	}
+
+	envelope := make([]byte, length)
+	if _, err := io.ReadFull(d.reader, envelope); err != nil {
+		return frame, unexpectedEOF(err)
+	}
+	d.raw = envelope
+	if err := json.Unmarshal(envelope, &frame); err != nil {
+		return frame, fmt.Errorf("%w: %v", ErrMalformedFrame, err)
+	}
+	if len(frame.Type) == 0 {
+		return frame, fmt.Errorf("%w: missing type", ErrMalformedFrame)
+	}
+
+	// the header is authoritative for the version of the frame
+	frame.Version = version
+	return frame, nil
+}
+
+func (d *Decoder) decodeLegacy() (Frame, error) {
+	frame := Frame{}
+	line, err := d.readLine()
+	if err != nil {
+		return frame, err
+	}
+	line = bytes.TrimRight(line, "\r\n")
+	d.raw = line
+
+	switch string(line) {
+	case legacyPing:
+		frame.Type = TypePing
+		return frame, nil
+	case legacyPong:
+		frame.Type = TypePong
+		return frame, nil
+	}
+
+	separatorIndex := bytes.IndexByte(line, legacySeparator)
+	if separatorIndex <= 0 {
+		return frame, fmt.Errorf("%w: missing event name", ErrMalformedFrame)
+	}
+	payload := line[separatorIndex+1:]
+	if !json.Valid(payload) {
+		return frame, fmt.Errorf("%w: invalid json payload", ErrMalformedFrame)
+	}
+
+	frame.Type = string(line[:separatorIndex])
+	frame.Payload = json.RawMessage(bytes.Clone(payload))
+	return frame, nil
+}
+
+// Raw returns the bytes of the last frame read, the json envelope of framed
+// frames and the line of text frames, including frames which failed to decode
+// it is only valid until the next call to Decode
+func (d *Decoder) Raw() []byte {
+	return d.raw
+}
+
+// this function reads a single text line without letting it grow past MaxFrameSize
+func (d *Decoder) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := d.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > MaxFrameSize {
+			return nil, fmt.Errorf("%w: text line longer than %d bytes", ErrFrameTooLarge, MaxFrameSize)
+		}
+		line = append(line, chunk...)
+
+		if err == nil {
+			return line, nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			if len(line) > 0 {
+				return nil, unexpectedEOF(err)
+			}
+			return nil, err
+		}
+	}
+}
+
+// a connection closing in the middle of a frame is not a clean end of stream
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Encoder writes frames in either the framed or the text format
+type Encoder struct {
+	writer io.Writer
+	legacy bool
+}
+
+// NewEncoder returns an encoder writing framed messages to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{writer: w}
+}
+
+// NewLegacyEncoder returns an encoder writing the text format to w
+// it is used while talking to servers which have not announced Capability
+func NewLegacyEncoder(w io.Writer) *Encoder {
+	return &Encoder{writer: w, legacy: true}
+}
+
+// Encode writes the frame using a single write call
+func (e *Encoder) Encode(frame Frame) error {
+	if len(frame.Type) == 0 {
+		return fmt.Errorf("%w: missing type", ErrMalformedFrame)
+	}
+
+	var message []byte
+	var err error
+	if e.legacy {
+		message, err = encodeLegacy(frame)
+	} else {
+		message, err = encodeFramed(frame)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = e.writer.Write(message)
+	return err
+}
+
+func encodeFramed(frame Frame) ([]byte, error) {
+	if frame.Version == 0 {
+		frame.Version = Version
+	}
+	if frame.Version > Version || frame.Version > 0xFF {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, frame.Version)
+	}
+
+	envelope, err := json.Marshal(frame)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope) > MaxFrameSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(envelope))
+	}
+
+	message := make([]byte, headerSize, headerSize+len(envelope))
+	message[0] = magic
+	message[1] = byte(frame.Version)
+	binary.BigEndian.PutUint32(message[2:], uint32(len(envelope)))
+	return append(message, envelope...), nil
+}
+
+func encodeLegacy(frame Frame) ([]byte, error) {
+	switch frame.Type {
+	case TypePing:
+		return []byte(legacyPing + "\n"), nil
+	case TypePong:
+		return []byte(legacyPong + "\n"), nil
+	}
+	if strings.ContainsAny(frame.Type, "|\r\n") {
+		return nil, fmt.Errorf("%w: type can not contain separator or newline", ErrMalformedFrame)
+	}
+
+	// compacting removes every raw newline because newlines inside json strings are escaped
+	var payload bytes.Buffer
+	if len(frame.Payload) > 0 {
+		if err := json.Compact(&payload, frame.Payload); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedFrame, err)
+		}
+	} else {
+		payload.WriteString("{}")
+	}
+
+	message := make([]byte, 0, len(frame.Type)+payload.Len()+2)
+	message = append(message, frame.Type...)
+	message = append(message, legacySeparator)
+	message = append(message, payload.Bytes()...)
+	return append(message, '\n'), nil
+}
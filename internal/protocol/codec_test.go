@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+)
+
+// this function builds a framed message by hand so that tests do not depend on the encoder
+func framedMessage(version byte, envelope []byte) []byte {
+	message := []byte{magic, version, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(message[2:], uint32(len(envelope)))
+	return append(message, envelope...)
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(framedMessage(Version, []byte(`{"type":"NEW_MESSAGE","id":"42","payload":{"id":"1"}}`)))
+	f.Add(framedMessage(Version, []byte(`{"type":"PING"}`)))
+	f.Add(framedMessage(2, []byte(`{"type":"NEW_MESSAGE"}`)))
+	f.Add(framedMessage(Version, []byte(`{"type":`)))
+	f.Add([]byte{magic, Version, 0xFF, 0xFF, 0xFF, 0xFF})
+	f.Add([]byte("NEW_MESSAGE|{\"id\":\"1\",\"description\":\"hi\"}\n"))
+	f.Add([]byte("_PING_\n_PONG_\n"))
+	f.Add([]byte("|{}\n"))
+	f.Add([]byte("EDIT_MESSAGE|{\"id\":"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decoder := NewDecoder(bytes.NewReader(data))
+		for range 16 {
+			frame, err := decoder.Decode()
+			if err != nil {
+				return
+			}
+			if len(frame.Type) == 0 {
+				t.Fatalf("decoded frame without type from %q", data)
+			}
+			if frame.Version < 0 || frame.Version > Version {
+				t.Fatalf("decoded frame with version %d from %q", frame.Version, data)
+			}
+			if len(frame.Payload) > 0 && !json.Valid(frame.Payload) {
+				t.Fatalf("decoded frame with invalid payload %q", frame.Payload)
+			}
+		}
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	frames := []Frame{
+		{Type: "NEW_MESSAGE", Payload: json.RawMessage(`{"id":"1","description":"line one\nline two"}`)},
+		{Type: "DELETE_MESSAGE", Payload: json.RawMessage(`{"id":"2"}`)},
+		{Type: TypePing},
+		{Type: TypePong},
+	}
+
+	tests := []struct {
+		name       string
+		newEncoder func(io.Writer) *Encoder
+		version    int
+	}{
+		{name: "framed", newEncoder: NewEncoder, version: Version},
+		{name: "legacy", newEncoder: NewLegacyEncoder, version: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			encoder := test.newEncoder(&buffer)
+			for _, frame := range frames {
+				if err := encoder.Encode(frame); err != nil {
+					t.Fatalf("error encoding %s: %v", frame.Type, err)
+				}
+			}
+
+			decoder := NewDecoder(&buffer)
+			for _, want := range frames {
+				got, err := decoder.Decode()
+				if err != nil {
+					t.Fatalf("error decoding %s: %v", want.Type, err)
+				}
+				if got.Type != want.Type || got.Version != test.version {
+					t.Errorf("decoded %s version %d, want %s version %d", got.Type, got.Version, want.Type, test.version)
+				}
+				if len(want.Payload) > 0 && !jsonEqual(t, got.Payload, want.Payload) {
+					t.Errorf("decoded payload %s, want %s", got.Payload, want.Payload)
+				}
+			}
+			if _, err := decoder.Decode(); !errors.Is(err, io.EOF) {
+				t.Errorf("expected io.EOF after last frame, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	body := []byte(`{"type":"NEW_MESSAGE","payload":{}}`)
+	oversize := []byte{magic, Version, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(oversize[2:], MaxFrameSize+1)
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  error
+	}{
+		{name: "unsupported version", input: framedMessage(Version+1, body), want: ErrUnsupportedVersion},
+		{name: "version zero", input: framedMessage(0, body), want: ErrUnsupportedVersion},
+		{name: "frame too large", input: oversize, want: ErrFrameTooLarge},
+		{name: "text line too large", input: append(bytes.Repeat([]byte("A"), MaxFrameSize+1), '\n'), want: ErrFrameTooLarge},
+		{name: "truncated body", input: framedMessage(Version, body)[:headerSize+len(body)/2], want: io.ErrUnexpectedEOF},
+		{name: "truncated header", input: framedMessage(Version, body)[:3], want: io.ErrUnexpectedEOF},
+		{name: "missing type", input: framedMessage(Version, []byte(`{"payload":{}}`)), want: ErrMalformedFrame},
+		{name: "text without name", input: []byte("|{}\n"), want: ErrMalformedFrame},
+		{name: "text with invalid json", input: []byte("NEW_MESSAGE|{\"id\":\n"), want: ErrMalformedFrame},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewDecoder(bytes.NewReader(test.input)).Decode()
+			if !errors.Is(err, test.want) {
+				t.Errorf("got error %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if err := NewEncoder(io.Discard).Encode(Frame{}); !errors.Is(err, ErrMalformedFrame) {
+		t.Errorf("encoding frame without type: got %v, want %v", err, ErrMalformedFrame)
+	}
+	if err := NewEncoder(io.Discard).Encode(Frame{Type: "NEW_MESSAGE", Version: Version + 1}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("encoding newer version: got %v, want %v", err, ErrUnsupportedVersion)
+	}
+	if err := NewLegacyEncoder(io.Discard).Encode(Frame{Type: "NEW|MESSAGE"}); !errors.Is(err, ErrMalformedFrame) {
+		t.Errorf("encoding type with separator: got %v, want %v", err, ErrMalformedFrame)
+	}
+}
+
+// this function compares two json documents ignoring formatting
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+
+	var compactA, compactB bytes.Buffer
+	if err := json.Compact(&compactA, a); err != nil {
+		t.Fatalf("invalid json %s: %v", a, err)
+	}
+	if err := json.Compact(&compactB, b); err != nil {
+		t.Fatalf("invalid json %s: %v", b, err)
+	}
+	return bytes.Equal(compactA.Bytes(), compactB.Bytes())
+}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+const (
+	reactionsFileName = "reactions.json"
+
+	// reactions of the messages reacted to longest ago are forgotten beyond this many messages
+	maxReactedMessages = 5000
+)
+
+// MessageReaction is an emoji a user has reacted to a message with
+type MessageReaction struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	Emoji     string    `json:"emoji"`
+	ReactedAt time.Time `json:"reacted_at"`
+}
+
+// LoadReactions reads the reactions kept by the deamon, keyed by message id
+func LoadReactions() (map[uuid.UUID][]MessageReaction, error) {
+	reactions := make(map[uuid.UUID][]MessageReaction)
+	if err := utility.ReadJSONFile(reactionsFileName, &reactions); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return reactions, fmt.Errorf("error reading %s: %w", reactionsFileName, err)
+	}
+
+	return reactions, nil
+}
+
+// ReactionRequest is sent to the deamon by message --react and --unreact
+type ReactionRequest struct {
+	MessageID  uuid.UUID `json:"message_id"`
+	ReceiverID uuid.UUID `json:"receiver_id,omitzero"`
+	GroupID    uuid.UUID `json:"group_id,omitzero"`
+	Emoji      string    `json:"emoji"`
+	Remove     bool      `json:"remove,omitempty"`
+}
+
+// reactions are kept in memory by the deamon and written in batches
+var reactions = newBatchedFile(reactionsFileName, LoadReactions)
+
+// this function adds or removes the reaction of a user to a message
+// a user reacts with an emoji at most once, reacting again changes nothing
+func applyReaction(reaction events.Reaction, reactedAt time.Time) error {
+	return reactions.update(func(stored map[uuid.UUID][]MessageReaction) {
+		messageReactions := make([]MessageReaction, 0, len(stored[reaction.MessageID])+1)
+		for _, existing := range stored[reaction.MessageID] {
+			if existing.UserID != reaction.UserID || existing.Emoji != reaction.Emoji {
+				messageReactions = append(messageReactions, existing)
+			} else if !reaction.Removed {
+				// keeping the time of the first reaction
+				reactedAt = existing.ReactedAt
+			}
+		}
+		if !reaction.Removed {
+			messageReactions = append(messageReactions, MessageReaction{
+				UserID:    reaction.UserID,
+				Username:  reaction.Username,
+				Emoji:     reaction.Emoji,
+				ReactedAt: reactedAt,
+			})
+		}
+		if len(messageReactions) == 0 {
+			delete(stored, reaction.MessageID)
+		} else {
+			stored[reaction.MessageID] = messageReactions
+		}
+
+		if overflow := len(stored) - maxReactedMessages; overflow > 0 {
+			latest := func(id uuid.UUID) time.Time {
+				last := time.Time{}
+				for _, existing := range stored[id] {
+					if existing.ReactedAt.After(last) {
+						last = existing.ReactedAt
+					}
+				}
+				return last
+			}
+			ids := make([]uuid.UUID, 0, len(stored))
+			for id := range stored {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(i, j int) bool { return latest(ids[i]).Before(latest(ids[j])) })
+			for _, id := range ids[:overflow] {
+				delete(stored, id)
+			}
+		}
+	})
+}
+
+// this function records a reaction received from the server
+func recordReaction(event events.Event, reaction *events.Reaction) error {
+	if reaction.MessageID == uuid.Nil || len(reaction.Emoji) == 0 {
+		return errors.New("reaction without message id or emoji")
+	}
+	reactedAt, err := time.Parse(time.RFC3339Nano, reaction.ReactedAt)
+	if err != nil {
+		reactedAt = time.Now().UTC()
+	}
+
+	return applyReaction(*reaction, reactedAt)
+}
+
+// this function sends the reaction of user to the server and records it
+// right away so that it is shown even before the server echoes it back
+func sendReaction(request ReactionRequest) error {
+	self, err := currentUser()
+	if err != nil {
+		return err
+	}
+
+	reactedAt := time.Now().UTC()
+	reaction := events.Reaction{
+		MessageID:  request.MessageID,
+		UserID:     self.UserID,
+		Username:   self.Username,
+		ReceiverID: request.ReceiverID,
+		GroupID:    request.GroupID,
+		Emoji:      request.Emoji,
+		Removed:    request.Remove,
+		ReactedAt:  reactedAt.Format(time.RFC3339Nano),
+	}
+	if err = sendToServer(events.REACTION, reaction); err != nil {
+		return err
+	}
+	if err = applyReaction(reaction, reactedAt); err != nil {
+		return err
+	}
+
+	// writing right away so that the cli shows the reaction
+	return reactions.save()
+}
+
+// this function subscribes the reactions to reaction events
+// replayed events would bring back reactions which were taken back since
+func registerReactions(bus *events.Bus) {
+	events.On(bus, events.REACTION, events.SkipReplay(recordReaction))
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+const (
+	receiptsFileName = "receipts.json"
+
+	// receipts of the oldest messages are forgotten beyond this many messages
+	maxReceipts = 5000
+)
+
+// MessageReader is a member of a group who has read a message
+type MessageReader struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+	ReadAt   time.Time `json:"read_at"`
+}
+
+// MessageReceipt is what the deamon has learned about the delivery of a sent message
+type MessageReceipt struct {
+	DeliveredAt time.Time       `json:"delivered_at,omitzero"`
+	Readers     []MessageReader `json:"readers,omitempty"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// Delivered reports whether the message reached its receiver
+func (r MessageReceipt) Delivered() bool {
+	return !r.DeliveredAt.IsZero() || len(r.Readers) > 0
+}
+
+// LoadReceipts reads the receipts kept by the deamon, keyed by message id
+func LoadReceipts() (map[uuid.UUID]MessageReceipt, error) {
+	receipts := make(map[uuid.UUID]MessageReceipt)
+	if err := utility.ReadJSONFile(receiptsFileName, &receipts); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return receipts, fmt.Errorf("error reading %s: %w", receiptsFileName, err)
+	}
+
+	return receipts, nil
+}
+
+// receipts are kept in memory by the deamon and written in batches
+var receipts = newBatchedFile(receiptsFileName, LoadReceipts)
+
+// this function applies an update to the receipt of a message
+func updateReceipt(messageID uuid.UUID, apply func(*MessageReceipt)) error {
+	return receipts.update(func(stored map[uuid.UUID]MessageReceipt) {
+		receipt := stored[messageID]
+		apply(&receipt)
+		receipt.UpdatedAt = time.Now().UTC()
+		stored[messageID] = receipt
+
+		if overflow := len(stored) - maxReceipts; overflow > 0 {
+			ids := make([]uuid.UUID, 0, len(stored))
+			for id := range stored {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(i, j int) bool { return stored[ids[i]].UpdatedAt.Before(stored[ids[j]].UpdatedAt) })
+			for _, id := range ids[:overflow] {
+				delete(stored, id)
+			}
+		}
+	})
+}
+
+// this function updates a message in the messages file written by conversation --open
+// nothing is done when the conversation has not been opened on this machine
+func updateCachedMessage(conversationID uuid.UUID, messageID uuid.UUID, apply func(*utility.Message)) error {
+	fileName := fmt.Sprintf("%s.json", conversationID.String())
+	messages := make(map[int]utility.Message)
+	if err := utility.ReadJSONFile(fileName, &messages); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for index, message := range messages {
+		if message.ID == messageID {
+			apply(&message)
+			messages[index] = message
+			return utility.WriteJSONFile(fileName, messages)
+		}
+	}
+
+	return nil
+}
+
+// this function records that a one to one message reached its receiver
+func recordDelivered(event events.Event, message *events.MessageReceived) error {
+	deliveredAt := eventTime(event.Payload)
+	if deliveredAt.IsZero() {
+		deliveredAt = time.Now().UTC()
+	}
+
+	err := updateReceipt(message.ID, func(receipt *MessageReceipt) {
+		if receipt.DeliveredAt.IsZero() {
+			receipt.DeliveredAt = deliveredAt
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return updateCachedMessage(message.ReceiverID, message.ID, func(cached *utility.Message) {
+		cached.Recieved = true
+	})
+}
+
+// this function records that a member of a group has read a message
+func recordGroupRead(event events.Event, message *events.GroupMessageRead) error {
+	readAt, err := time.Parse(time.RFC3339Nano, message.ReadAt)
+	if err != nil {
+		readAt = time.Now().UTC()
+	}
+
+	return updateReceipt(message.ID, func(receipt *MessageReceipt) {
+		for _, reader := range receipt.Readers {
+			if reader.ID == message.GroupMemberID {
+				return
+			}
+		}
+		receipt.Readers = append(receipt.Readers, MessageReader{
+			ID:       message.GroupMemberID,
+			Username: message.GroupMemberUsername,
+			ReadAt:   readAt,
+		})
+	})
+}
+
+// this function records that the receiver of a one to one message has read it
+func recordRead(event events.Event, message *events.MessageRead) error {
+	return updateCachedMessage(message.ReaderID, message.ID, func(cached *utility.Message) {
+		cached.Recieved = true
+		cached.Read = true
+	})
+}
+
+// this function subscribes the receipts to delivery and read events
+// replayed events would bring back receipts and cached messages as they were
+func registerReceipts(bus *events.Bus) {
+	events.On(bus, events.MESSAGE_RECEIVED, events.SkipReplay(recordDelivered))
+	events.On(bus, events.GROUP_MESSAGE_READ, events.SkipReplay(recordGroupRead))
+	events.On(bus, events.MESSAGE_READ, events.SkipReplay(recordRead))
+}
@@ -0,0 +1,370 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+	"github.com/harshvardha/TerTerChatCLI/internal/protocol"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+const (
+	cursorFile = "event_cursor.json"
+
+	// number of dedupe keys remembered, enough to cover a replay overlapping a catch-up sync
+	maxSeenEvents = 1000
+
+	// the cursor is written at most this often and once more on shutdown
+	cursorSaveInterval = 5 * time.Second
+
+	// pages of older messages fetched per conversation by a catch-up sync
+	maxCatchUpPages = 50
+)
+
+// resumeCursor is sent in the hello frame so that the server can replay
+// every event emitted after it
+type resumeCursor struct {
+	EventID string    `json:"event_id,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+// eventCursor remembers the last event handled by the deamon and the keys
+// of recently handled events so that replayed events are not handled twice
+type eventCursor struct {
+	mu          sync.Mutex
+	LastEventID string    `json:"last_event_id,omitempty"`
+	LastEventAt time.Time `json:"last_event_at"`
+	SeenEvents  []string  `json:"seen_events"`
+	seen        map[string]struct{}
+
+	// set when the cursor moved since it was last written
+	dirty bool
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+var cursor = &eventCursor{seen: make(map[string]struct{})}
+
+// fields shared by event payloads which are used for dedupe and ordering
+type eventMetadata struct {
+	ID        uuid.UUID `json:"id"`
+	EmittedAt string    `json:"emittedAt"`
+	CreatedAt string    `json:"created_at"`
+	UpdatedAt string    `json:"updated_at"`
+}
+
+// this function loads the cursor persisted by a previous run of the deamon
+func (c *eventCursor) load() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := utility.ReadJSONFile(cursorFile, c); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error loading event cursor: %v", err)
+	}
+	c.seen = make(map[string]struct{}, len(c.SeenEvents))
+	for _, key := range c.SeenEvents {
+		c.seen[key] = struct{}{}
+	}
+}
+
+// this function writes the cursor every cursorSaveInterval while it keeps moving
+func (c *eventCursor) start() {
+	c.quit = make(chan struct{})
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(cursorSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.save()
+			case <-c.quit:
+				c.save()
+				return
+			}
+		}
+	}()
+}
+
+// this function stops the periodic writes after writing the cursor a last time
+func (c *eventCursor) stop() {
+	if c.quit == nil {
+		return
+	}
+	close(c.quit)
+	<-c.done
+}
+
+// this function writes the cursor when it moved since the last write
+func (c *eventCursor) save() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return
+	}
+	if err := utility.WriteJSONFile(cursorFile, c); err != nil {
+		log.Printf("Error saving event cursor: %v", err)
+		return
+	}
+	c.dirty = false
+}
+
+// this function returns the cursor to resume from or nil on first run
+func (c *eventCursor) resume() *resumeCursor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.LastEventAt.IsZero() && len(c.LastEventID) == 0 {
+		return nil
+	}
+	return &resumeCursor{EventID: c.LastEventID, Since: c.LastEventAt}
+}
+
+func (c *eventCursor) since() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.LastEventAt
+}
+
+// this function reports whether the frame was already handled
+func (c *eventCursor) isDuplicate(frame protocol.Frame) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range dedupeKeys(frame) {
+		if _, ok := c.seen[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// this function moves the cursor past the frame, it is written by the next save
+// only timestamps carried by the payload move LastEventAt
+func (c *eventCursor) advance(frame protocol.Frame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range dedupeKeys(frame) {
+		c.seen[key] = struct{}{}
+		c.SeenEvents = append(c.SeenEvents, key)
+	}
+	if len(c.SeenEvents) > maxSeenEvents {
+		for _, key := range c.SeenEvents[:len(c.SeenEvents)-maxSeenEvents] {
+			delete(c.seen, key)
+		}
+		c.SeenEvents = append([]string(nil), c.SeenEvents[len(c.SeenEvents)-maxSeenEvents:]...)
+	}
+
+	if len(frame.ID) > 0 {
+		c.LastEventID = frame.ID
+	}
+	if emittedAt := eventTime(frame.Payload); emittedAt.After(c.LastEventAt) {
+		c.LastEventAt = emittedAt
+	}
+	c.dirty = true
+}
+
+// this function returns the keys identifying a frame
+// frames carry an id in framed protocol, message events are also keyed by the
+// message uuid so that the same message from replay and from REST sync match
+func dedupeKeys(frame protocol.Frame) []string {
+	keys := []string{}
+	if len(frame.ID) > 0 {
+		keys = append(keys, "frame:"+frame.ID)
+	}
+
+	metadata := eventMetadata{}
+	if err := json.Unmarshal(frame.Payload, &metadata); err != nil || metadata.ID == uuid.Nil {
+		return keys
+	}
+	switch frame.Type {
+	case events.NEW_MESSAGE, events.DELETE_MESSAGE:
+		keys = append(keys, fmt.Sprintf("%s:%s", frame.Type, metadata.ID))
+	case events.EDIT_MESSAGE:
+		keys = append(keys, fmt.Sprintf("%s:%s:%s", frame.Type, metadata.ID, metadata.UpdatedAt))
+	}
+
+	return keys
+}
+
+// this function returns the time at which an event happened using the first
+// timestamp present in its payload, or the zero time when it carries none
+func eventTime(payload []byte) time.Time {
+	metadata := eventMetadata{}
+	if err := json.Unmarshal(payload, &metadata); err == nil {
+		for _, timestamp := range []string{metadata.EmittedAt, metadata.UpdatedAt, metadata.CreatedAt} {
+			if parsed, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+				return parsed
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// this function fetches the messages of every cached conversation created
+// after the cursor and pushes them through the event pipeline as NEW_MESSAGE
+// it is used when the server can not replay the events we missed
+func catchUp(since time.Time) {
+	log.Printf("Server can not replay events, syncing conversations since %s", since.Format(time.RFC1123))
+	self, err := currentUser()
+	if err != nil {
+		log.Printf("Error reading current user for catch-up sync: %v", err)
+	}
+
+	missed := []protocol.Frame{}
+	oneToOneConversations := make(map[int]utility.OneToOneConversation)
+	if err = utility.ReadJSONFile("one_to_one.json", &oneToOneConversations); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error reading one to one conversations for catch-up sync: %v", err)
+	}
+	for _, conversation := range oneToOneConversations {
+		messages, err := fetchMessagesSince(since, func(before time.Time) ([]utility.Message, error) {
+			return fetchMessages("/message/conversation", struct {
+				ReceiverID uuid.NullUUID `json:"receiver_id"`
+				CreatedAt  time.Time     `json:"created_at"`
+			}{
+				ReceiverID: uuid.NullUUID{UUID: conversation.ReceiverID, Valid: true},
+				CreatedAt:  before,
+			})
+		})
+		if err != nil {
+			log.Printf("Error syncing conversation with %s: %v", conversation.Username, err)
+			continue
+		}
+
+		for _, message := range messages {
+			if message.CreatedAt.After(since) && message.SenderID == conversation.ReceiverID {
+				missed = append(missed, newMessageFrame(message, conversation.Username))
+			}
+		}
+	}
+
+	// group.json is written by conversation --list and holds every group of the user,
+	// not only the ones created on this machine
+	groupConversations := make(map[int]utility.GroupConversation)
+	if err = utility.ReadJSONFile("group.json", &groupConversations); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error reading group conversations for catch-up sync: %v", err)
+	}
+	for _, conversation := range groupConversations {
+		messages, err := fetchMessagesSince(since, func(before time.Time) ([]utility.Message, error) {
+			return fetchMessages("/message/group/all", struct {
+				GroupID uuid.UUID `json:"group_id"`
+				Before  time.Time `json:"before"`
+			}{
+				GroupID: conversation.GroupID.UUID,
+				Before:  before,
+			})
+		})
+		if err != nil {
+			log.Printf("Error syncing group %s: %v", conversation.GroupName, err)
+			continue
+		}
+
+		for _, message := range messages {
+			if message.CreatedAt.After(since) && message.SenderID != self.UserID {
+				missed = append(missed, newMessageFrame(message, conversation.GroupName))
+			}
+		}
+	}
+
+	// handling missed messages in the order they were created
+	sort.SliceStable(missed, func(i, j int) bool {
+		return eventTime(missed[i].Payload).Before(eventTime(missed[j].Payload))
+	})
+	for _, frame := range missed {
+		journal.record(SourceCatchUp, frame, frame.Payload, nil)
+		eventQueue.enqueue(frame)
+	}
+	log.Printf("Catch-up sync finished, %d missed messages", len(missed))
+}
+
+// this function pages back through the messages of a conversation, newest page
+// first, until it reaches messages created before since
+func fetchMessagesSince(since time.Time, fetchPage func(before time.Time) ([]utility.Message, error)) ([]utility.Message, error) {
+	messages := []utility.Message{}
+	before := time.Now()
+	for range maxCatchUpPages {
+		page, err := fetchPage(before)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, page...)
+
+		oldest := before
+		for _, message := range page {
+			if message.CreatedAt.Before(oldest) {
+				oldest = message.CreatedAt
+			}
+		}
+		// an empty page, or one which did not go further back, is the end of the conversation
+		if !oldest.Before(before) || !oldest.After(since) {
+			return messages, nil
+		}
+		before = oldest
+	}
+
+	log.Printf("Catch-up sync stopped after %d pages, older missed messages are not synced", maxCatchUpPages)
+	return messages, nil
+}
+
+// this function fetches the messages of a conversation from REST api
+func fetchMessages(path string, body any) ([]utility.Message, error) {
+	request, err := newAPIRequest("GET", path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server responded with %s", response.Status)
+	}
+	messages := utility.DecodeResponseBody(response.Body, &utility.ConversationMessages{}).(*utility.ConversationMessages)
+	updateAuthToken(messages.AccessToken)
+
+	return messages.Messages, nil
+}
+
+// this function converts a message fetched from REST api into a NEW_MESSAGE frame
+// replies keep their parent so that threads synced by catch-up stay intact
+func newMessageFrame(message utility.Message, senderUsername string) protocol.Frame {
+	var attachment *events.Attachment
+	if message.Attachment != nil {
+		attachment = &events.Attachment{
+			ID:       message.Attachment.ID,
+			FileName: message.Attachment.FileName,
+			Size:     message.Attachment.Size,
+			MimeType: message.Attachment.MimeType,
+			Checksum: message.Attachment.Checksum,
+		}
+	}
+	payload, _ := json.Marshal(events.Message{
+		ID:             message.ID,
+		GroupID:        message.GroupID.UUID,
+		SenderID:       message.SenderID,
+		SenderUsername: senderUsername,
+		Description:    message.Description,
+		ParentID:       message.ParentID.UUID,
+		Attachment:     attachment,
+		CreatedAt:      message.CreatedAt.Format(time.RFC3339Nano),
+	})
+
+	return protocol.Frame{Type: events.NEW_MESSAGE, Payload: payload}
+}
@@ -0,0 +1,331 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+const (
+	rulesFileName = "notify_rules.json"
+
+	// layout of the start and end of a do not disturb window
+	clockLayout = "15:04"
+)
+
+// kinds of notification rules
+const (
+	RuleMute     = "mute"
+	RuleDND      = "dnd"
+	RuleMentions = "mentions"
+	RuleKeyword  = "keyword"
+	RuleOwnEdits = "own-edits"
+)
+
+// RuleKinds lists the kinds of rules in the order they are described to user
+var RuleKinds = []string{RuleMute, RuleDND, RuleMentions, RuleKeyword, RuleOwnEdits}
+
+// NotifyRule is a single rule evaluated before a message is shown as notification
+type NotifyRule struct {
+	ID   int    `json:"id"`
+	Kind string `json:"kind"`
+
+	// conversation the rule applies to, the group id for groups and the
+	// receiver id for one to one conversations
+	// mentions rules without a conversation apply to every group
+	ConversationID   uuid.UUID `json:"conversation_id,omitzero"`
+	ConversationName string    `json:"conversation_name,omitempty"`
+
+	// a mute without expiry lasts until the rule is removed
+	Until time.Time `json:"until,omitzero"`
+
+	// do not disturb window in local time, the window may wrap past midnight
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	// keyword highlights match case insensitively unless the pattern is a regular expression
+	Pattern string `json:"pattern,omitempty"`
+	Regex   bool   `json:"regex,omitempty"`
+}
+
+// NotifyRules is the content of the rules file shared by cli and deamon
+type NotifyRules struct {
+	NextID int          `json:"next_id"`
+	Rules  []NotifyRule `json:"rules"`
+}
+
+// LoadNotifyRules reads the notification rules, a missing file means no rules
+func LoadNotifyRules() (NotifyRules, error) {
+	rules := NotifyRules{}
+	if err := utility.ReadJSONFile(rulesFileName, &rules); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return rules, fmt.Errorf("error reading %s: %w", rulesFileName, err)
+	}
+
+	return rules, nil
+}
+
+// SaveNotifyRules writes the notification rules, the deamon picks them up on next message
+func SaveNotifyRules(rules NotifyRules) error {
+	return utility.WriteJSONFile(rulesFileName, rules)
+}
+
+// Add validates the rule and appends it with a new id
+func (r *NotifyRules) Add(rule NotifyRule) (NotifyRule, error) {
+	if err := rule.validate(); err != nil {
+		return rule, err
+	}
+
+	// ids are never reused so that a removed rule can not be confused with a new one
+	if r.NextID == 0 {
+		r.NextID = 1
+	}
+	for _, existing := range r.Rules {
+		if existing.ID >= r.NextID {
+			r.NextID = existing.ID + 1
+		}
+	}
+	rule.ID = r.NextID
+	r.NextID++
+	r.Rules = append(r.Rules, rule)
+
+	return rule, nil
+}
+
+// Remove deletes the rule with given id and reports whether it existed
+func (r *NotifyRules) Remove(id int) bool {
+	for index, rule := range r.Rules {
+		if rule.ID == id {
+			r.Rules = append(r.Rules[:index], r.Rules[index+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// this function checks that a rule has everything its kind needs
+func (rule NotifyRule) validate() error {
+	switch rule.Kind {
+	case RuleMute:
+		if rule.ConversationID == uuid.Nil {
+			return errors.New("mute rule needs a conversation")
+		}
+	case RuleDND:
+		if _, err := time.Parse(clockLayout, rule.From); err != nil {
+			return fmt.Errorf("invalid start of do not disturb window %q, expected HH:MM", rule.From)
+		}
+		if _, err := time.Parse(clockLayout, rule.To); err != nil {
+			return fmt.Errorf("invalid end of do not disturb window %q, expected HH:MM", rule.To)
+		}
+	case RuleMentions, RuleOwnEdits:
+	case RuleKeyword:
+		if len(rule.Pattern) == 0 {
+			return errors.New("keyword rule needs a pattern")
+		}
+		if _, err := rule.compile(); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", rule.Pattern, err)
+		}
+	default:
+		return fmt.Errorf("unknown rule kind %q, expected one of %s", rule.Kind, strings.Join(RuleKinds, ", "))
+	}
+
+	return nil
+}
+
+// this function compiles the pattern of a keyword rule
+func (rule NotifyRule) compile() (*regexp.Regexp, error) {
+	if rule.Regex {
+		return regexp.Compile(rule.Pattern)
+	}
+	return regexp.Compile("(?i)" + regexp.QuoteMeta(rule.Pattern))
+}
+
+// Expired reports whether a mute rule has run out
+func (rule NotifyRule) Expired(now time.Time) bool {
+	return rule.Kind == RuleMute && !rule.Until.IsZero() && !now.Before(rule.Until)
+}
+
+// this function reports whether the do not disturb window covers the given time
+func (rule NotifyRule) covers(now time.Time) bool {
+	from, err := time.Parse(clockLayout, rule.From)
+	if err != nil {
+		return false
+	}
+	to, err := time.Parse(clockLayout, rule.To)
+	if err != nil {
+		return false
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	start := from.Hour()*60 + from.Minute()
+	end := to.Hour()*60 + to.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// String describes the rule for notify rules list
+func (rule NotifyRule) String() string {
+	conversation := rule.ConversationName
+	if len(conversation) == 0 && rule.ConversationID != uuid.Nil {
+		conversation = rule.ConversationID.String()
+	}
+
+	switch rule.Kind {
+	case RuleMute:
+		if rule.Until.IsZero() {
+			return fmt.Sprintf("mute %s", conversation)
+		}
+		return fmt.Sprintf("mute %s until %s", conversation, rule.Until.Local().Format("2006-01-02 15:04"))
+	case RuleDND:
+		return fmt.Sprintf("do not disturb from %s to %s", rule.From, rule.To)
+	case RuleMentions:
+		if len(conversation) == 0 {
+			return "only mentions in all groups"
+		}
+		return fmt.Sprintf("only mentions in %s", conversation)
+	case RuleKeyword:
+		if rule.Regex {
+			return fmt.Sprintf("highlight /%s/", rule.Pattern)
+		}
+		return fmt.Sprintf("highlight %q", rule.Pattern)
+	case RuleOwnEdits:
+		return "suppress own edits"
+	default:
+		return rule.Kind
+	}
+}
+
+// rulesCache keeps the rules file parsed between messages
+// the file is read again whenever the cli changes it
+type rulesCache struct {
+	mu       sync.Mutex
+	modTime  time.Time
+	rules    []NotifyRule
+	patterns map[int]*regexp.Regexp
+}
+
+var notifyRules = &rulesCache{}
+
+// this function returns the current rules and compiled keyword patterns
+func (c *rulesCache) current() ([]NotifyRule, map[int]*regexp.Regexp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(rulesFileName)
+	if err != nil {
+		c.modTime = time.Time{}
+		c.rules = nil
+		c.patterns = nil
+		return nil, nil
+	}
+	if info.ModTime().Equal(c.modTime) {
+		return c.rules, c.patterns
+	}
+
+	rules, err := LoadNotifyRules()
+	if err != nil {
+		// keeping the last good rules rather than notifying for everything
+		return c.rules, c.patterns
+	}
+	patterns := make(map[int]*regexp.Regexp)
+	for _, rule := range rules.Rules {
+		if rule.Kind != RuleKeyword {
+			continue
+		}
+		pattern, err := rule.compile()
+		if err != nil {
+			continue
+		}
+		patterns[rule.ID] = pattern
+	}
+	c.modTime = info.ModTime()
+	c.rules = rules.Rules
+	c.patterns = patterns
+
+	return c.rules, c.patterns
+}
+
+// notifyDecision is the outcome of evaluating the rules for a message
+type notifyDecision struct {
+	notify    bool
+	highlight bool
+	reason    string
+}
+
+// this function evaluates the notification rules for a new or edited message
+// keyword highlights get through mutes and only mentions rules but
+// do not disturb windows and own edits are always quiet
+func evaluateRules(name string, message *events.Message, now time.Time) notifyDecision {
+	rules, patterns := notifyRules.current()
+	if len(rules) == 0 {
+		return notifyDecision{notify: true}
+	}
+
+	self, _ := currentUser()
+	conversationID := message.SenderID
+	if message.GroupID != uuid.Nil {
+		conversationID = message.GroupID
+	}
+
+	decision := notifyDecision{notify: true}
+	for _, rule := range rules {
+		if rule.Kind != RuleKeyword {
+			continue
+		}
+		if pattern, ok := patterns[rule.ID]; ok && pattern.MatchString(message.Description) {
+			decision.highlight = true
+			break
+		}
+	}
+
+	for _, rule := range rules {
+		switch rule.Kind {
+		case RuleOwnEdits:
+			if name == events.EDIT_MESSAGE && self.UserID != uuid.Nil && message.SenderID == self.UserID {
+				return notifyDecision{reason: "own edit"}
+			}
+		case RuleDND:
+			if rule.covers(now) {
+				return notifyDecision{reason: "do not disturb"}
+			}
+		case RuleMute:
+			if !decision.highlight && rule.ConversationID == conversationID && !rule.Expired(now) {
+				decision = notifyDecision{reason: "muted"}
+			}
+		case RuleMentions:
+			if decision.highlight || message.GroupID == uuid.Nil {
+				continue
+			}
+			if rule.ConversationID != uuid.Nil && rule.ConversationID != message.GroupID {
+				continue
+			}
+			if !mentions(message.Description, self.Username) {
+				decision = notifyDecision{reason: "not mentioned"}
+			}
+		}
+	}
+
+	return decision
+}
+
+// this function reports whether the text mentions the user as @username
+func mentions(text string, username string) bool {
+	if len(username) == 0 {
+		return false
+	}
+	pattern, err := regexp.Compile(`(?i)(^|[^\w])@` + regexp.QuoteMeta(username) + `\b`)
+	if err != nil {
+		return false
+	}
+	return pattern.MatchString(text)
+}
@@ -0,0 +1,452 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+// MaxMessageLength is the longest message which can be sent, in characters
+const MaxMessageLength = 4000
+
+const (
+	scheduleFileName = "scheduled.json"
+
+	// a scheduled message which could not be sent this many times is given up
+	maxScheduleAttempts = 10
+
+	// delays between attempts to send a scheduled message
+	scheduleRetryMin = time.Minute
+	scheduleRetryMax = 30 * time.Minute
+)
+
+// ScheduledMessage is a message the deamon sends once SendAt has passed
+type ScheduledMessage struct {
+	ID int `json:"id"`
+
+	// the receiver id for one to one conversations and the group id for groups
+	ConversationID   uuid.UUID `json:"conversation_id"`
+	ConversationName string    `json:"conversation_name"`
+	IsGroup          bool      `json:"is_group,omitempty"`
+
+	Description string    `json:"description"`
+	ParentID    uuid.UUID `json:"parent_id,omitzero"`
+	SendAt      time.Time `json:"send_at"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// failed attempts, the message is retried at NextAttempt until it is given up
+	Attempts    int       `json:"attempts,omitempty"`
+	NextAttempt time.Time `json:"next_attempt,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+	Failed      bool      `json:"failed,omitempty"`
+
+	// set while the message is being sent, a message still marked when the deamon
+	// starts may have reached the server and is given up rather than sent twice
+	SendingSince time.Time `json:"sending_since,omitzero"`
+}
+
+// ScheduleEdit changes the text or the time of a scheduled message, empty fields are kept
+type ScheduleEdit struct {
+	ID          int       `json:"id"`
+	Description string    `json:"description,omitempty"`
+	SendAt      time.Time `json:"send_at,omitzero"`
+}
+
+// ScheduledMessages is the content of the schedule file shared by cli and deamon
+type ScheduledMessages struct {
+	NextID   int                `json:"next_id"`
+	Messages []ScheduledMessage `json:"messages"`
+}
+
+// LoadSchedule reads the scheduled messages, a missing file means nothing is scheduled
+func LoadSchedule() (ScheduledMessages, error) {
+	scheduled := ScheduledMessages{}
+	if err := utility.ReadJSONFile(scheduleFileName, &scheduled); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return scheduled, fmt.Errorf("error reading %s: %w", scheduleFileName, err)
+	}
+
+	return scheduled, nil
+}
+
+// scheduleStore serialises the updates of the schedule file
+// while the deamon runs every update goes through it, the cli only writes
+// the file itself when the deamon is not running
+type scheduleStore struct {
+	mu sync.Mutex
+}
+
+var schedule = &scheduleStore{}
+
+// this function applies an update to the scheduled messages and writes the file
+// nothing is written when apply fails
+func (s *scheduleStore) update(apply func(*ScheduledMessages) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scheduled, err := LoadSchedule()
+	if err != nil {
+		return err
+	}
+	if err = apply(&scheduled); err != nil {
+		return err
+	}
+
+	return utility.WriteJSONFile(scheduleFileName, scheduled)
+}
+
+// this function checks the text of a scheduled message
+func validateScheduledText(description string) error {
+	if len(description) == 0 {
+		return errors.New("scheduled message is empty")
+	}
+	if utf8.RuneCountInString(description) > MaxMessageLength {
+		return fmt.Errorf("scheduled message is longer than %d characters", MaxMessageLength)
+	}
+	return nil
+}
+
+// this function checks that a message is scheduled in the future
+func validateSendAt(sendAt time.Time) error {
+	if !sendAt.After(time.Now()) {
+		return fmt.Errorf("%s has already passed", sendAt.Local().Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// AddScheduled stores a message to be sent at message.SendAt and returns it with its id
+func AddScheduled(message ScheduledMessage) (ScheduledMessage, error) {
+	if message.ConversationID == uuid.Nil {
+		return message, errors.New("scheduled message needs a conversation")
+	}
+	if err := validateScheduledText(message.Description); err != nil {
+		return message, err
+	}
+	if err := validateSendAt(message.SendAt); err != nil {
+		return message, err
+	}
+
+	err := schedule.update(func(scheduled *ScheduledMessages) error {
+		// ids are never reused so that a cancelled message can not be confused with a new one
+		if scheduled.NextID == 0 {
+			scheduled.NextID = 1
+		}
+		for _, existing := range scheduled.Messages {
+			if existing.ID >= scheduled.NextID {
+				scheduled.NextID = existing.ID + 1
+			}
+		}
+		message.ID = scheduled.NextID
+		message.CreatedAt = time.Now().UTC()
+		scheduled.NextID++
+		scheduled.Messages = append(scheduled.Messages, message)
+		return nil
+	})
+
+	return message, err
+}
+
+// CancelScheduled removes a message which has not been sent yet
+func CancelScheduled(id int) error {
+	return schedule.update(func(scheduled *ScheduledMessages) error {
+		for index, message := range scheduled.Messages {
+			if message.ID == id {
+				if !message.SendingSince.IsZero() {
+					return fmt.Errorf("scheduled message %d is being sent", id)
+				}
+				scheduled.Messages = append(scheduled.Messages[:index], scheduled.Messages[index+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("no scheduled message with id %d", id)
+	})
+}
+
+// EditScheduled changes a message which has not been sent yet
+// a message which was given up is tried again from scratch
+func EditScheduled(edit ScheduleEdit) error {
+	return schedule.update(func(scheduled *ScheduledMessages) error {
+		for index, message := range scheduled.Messages {
+			if message.ID != edit.ID {
+				continue
+			}
+			if !message.SendingSince.IsZero() {
+				return fmt.Errorf("scheduled message %d is being sent", edit.ID)
+			}
+			if len(edit.Description) > 0 {
+				if err := validateScheduledText(edit.Description); err != nil {
+					return err
+				}
+				message.Description = edit.Description
+			}
+			if !edit.SendAt.IsZero() {
+				if err := validateSendAt(edit.SendAt); err != nil {
+					return err
+				}
+				message.SendAt = edit.SendAt
+			}
+			message.Attempts = 0
+			message.NextAttempt = time.Time{}
+			message.LastError = ""
+			message.Failed = false
+			scheduled.Messages[index] = message
+			return nil
+		}
+		return fmt.Errorf("no scheduled message with id %d", edit.ID)
+	})
+}
+
+// messageScheduler sends scheduled messages once they are due
+// messages due while the deamon was not running are sent when it starts
+type messageScheduler struct {
+	wake chan struct{}
+	quit chan struct{}
+	done chan struct{}
+}
+
+// scheduler is started along with the deamon
+var scheduler = newMessageScheduler()
+
+func newMessageScheduler() *messageScheduler {
+	return &messageScheduler{
+		wake: make(chan struct{}, 1),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// this function starts sending scheduled messages as they become due
+func (s *messageScheduler) start() {
+	s.giveUpInFlight()
+	go s.run()
+}
+
+// this function gives up the messages a previous run of the deamon was sending
+// when it stopped, the server may have received them so they are not sent again
+// unless the user edits them
+func (s *messageScheduler) giveUpInFlight() {
+	err := schedule.update(func(scheduled *ScheduledMessages) error {
+		for index, message := range scheduled.Messages {
+			if message.SendingSince.IsZero() {
+				continue
+			}
+			log.Printf("Scheduled message %d to %s was being sent when the deamon stopped, not sending it again", message.ID, message.ConversationName)
+			message.SendingSince = time.Time{}
+			message.Failed = true
+			message.LastError = "the deamon stopped while sending it, it may have been sent"
+			scheduled.Messages[index] = message
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error updating scheduled messages: %v", err)
+	}
+}
+
+// this function stops the scheduler, unsent messages stay in the schedule file
+func (s *messageScheduler) stop() {
+	close(s.quit)
+	<-s.done
+}
+
+// this function makes the scheduler look at the schedule file again after it changed
+func (s *messageScheduler) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *messageScheduler) run() {
+	defer close(s.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+
+		// sending everything due before sleeping until the next message
+		for s.sendNext() {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+		}
+
+		timer.Reset(s.untilNext())
+	}
+}
+
+// this function sends the earliest due message and reports whether one was due
+// the message is marked in the schedule file before it is sent so that it is
+// sent at most once even when the deamon stops half way, a message whose
+// request failed without an answer from the server is retried and may
+// arrive twice if the server had received it
+func (s *messageScheduler) sendNext() bool {
+	now := time.Now()
+	message := ScheduledMessage{}
+	err := schedule.update(func(scheduled *ScheduledMessages) error {
+		due := -1
+		for index, message := range scheduled.Messages {
+			if message.Failed || !message.SendingSince.IsZero() || now.Before(message.SendAt) || now.Before(message.NextAttempt) {
+				continue
+			}
+			if due < 0 || message.SendAt.Before(scheduled.Messages[due].SendAt) {
+				due = index
+			}
+		}
+		if due < 0 {
+			return errNothingDue
+		}
+
+		scheduled.Messages[due].SendingSince = now
+		message = scheduled.Messages[due]
+		return nil
+	})
+	if errors.Is(err, errNothingDue) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Error updating scheduled messages: %v", err)
+		return false
+	}
+
+	sendErr := sendScheduled(message)
+	err = schedule.update(func(scheduled *ScheduledMessages) error {
+		for index, stored := range scheduled.Messages {
+			if stored.ID != message.ID {
+				continue
+			}
+			if sendErr == nil {
+				if late := now.Sub(message.SendAt); late > time.Minute {
+					log.Printf("Sent scheduled message %d to %s %s late", message.ID, message.ConversationName, late.Round(time.Second))
+				} else {
+					log.Printf("Sent scheduled message %d to %s", message.ID, message.ConversationName)
+				}
+				scheduled.Messages = append(scheduled.Messages[:index], scheduled.Messages[index+1:]...)
+				return nil
+			}
+
+			stored.SendingSince = time.Time{}
+			stored.Attempts++
+			stored.LastError = sendErr.Error()
+			if errors.Is(sendErr, errRejected) || stored.Attempts >= maxScheduleAttempts {
+				stored.Failed = true
+				log.Printf("Giving up scheduled message %d to %s: %v", stored.ID, stored.ConversationName, sendErr)
+				if err := pushNotification("scheduled message not sent", fmt.Sprintf("to %s: %v", stored.ConversationName, sendErr)); err != nil {
+					log.Printf("Error showing notification: %v", err)
+				}
+			} else {
+				stored.NextAttempt = now.Add(scheduleBackoff(stored.Attempts))
+				log.Printf("Error sending scheduled message %d, retrying at %s: %v", stored.ID, stored.NextAttempt.Format(time.RFC3339), sendErr)
+			}
+			scheduled.Messages[index] = stored
+			return nil
+		}
+		return fmt.Errorf("scheduled message %d is no longer in the schedule", message.ID)
+	})
+	if err != nil {
+		log.Printf("Error updating scheduled messages: %v", err)
+	}
+
+	return true
+}
+
+// this function returns how long to sleep until the next message is due
+// the schedule is looked at least once a minute in case the file was changed directly
+func (s *messageScheduler) untilNext() time.Duration {
+	scheduled, err := LoadSchedule()
+	if err != nil {
+		log.Print(err)
+		return time.Minute
+	}
+
+	next := time.Now().Add(time.Minute)
+	for _, message := range scheduled.Messages {
+		if message.Failed {
+			continue
+		}
+		at := message.SendAt
+		if message.NextAttempt.After(at) {
+			at = message.NextAttempt
+		}
+		if at.Before(next) {
+			next = at
+		}
+	}
+
+	return max(time.Until(next), 0)
+}
+
+// errNothingDue stops an update of the schedule when no message is due
+var errNothingDue = errors.New("no scheduled message is due")
+
+// errRejected marks a scheduled message the server refused, it is not retried
+var errRejected = errors.New("server rejected message")
+
+// this function returns the delay before the next attempt after the given number of failures
+func scheduleBackoff(attempts int) time.Duration {
+	delay := scheduleRetryMin
+	for range attempts - 1 {
+		delay *= 2
+		if delay >= scheduleRetryMax {
+			return scheduleRetryMax
+		}
+	}
+	return delay
+}
+
+// this function sends a scheduled message through the REST api
+func sendScheduled(message ScheduledMessage) error {
+	body := struct {
+		Description string `json:"description"`
+		ReceiverID  string `json:"receiver_id"`
+		GroupID     string `json:"group_id"`
+		ParentID    string `json:"parent_id,omitempty"`
+	}{
+		Description: message.Description,
+	}
+	if message.IsGroup {
+		body.GroupID = message.ConversationID.String()
+	} else {
+		body.ReceiverID = message.ConversationID.String()
+	}
+	if message.ParentID != uuid.Nil {
+		body.ParentID = message.ParentID.String()
+	}
+
+	request, err := newAPIRequest("POST", "/message/create", body)
+	if err != nil {
+		return err
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusCreated:
+		emptyResponse := utility.DecodeResponseBody(response.Body, &utility.EmptyResponse{}).(*utility.EmptyResponse)
+		updateAuthToken(emptyResponse.AccessToken)
+		return nil
+	case http.StatusBadRequest, http.StatusNotAcceptable:
+		errorResponse := utility.DecodeResponseBody(response.Body, &utility.ErrorResponse{}).(*utility.ErrorResponse)
+		return fmt.Errorf("%w: %s", errRejected, errorResponse.Error)
+	default:
+		return fmt.Errorf("server responded with %s", response.Status)
+	}
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// this function reads the credentials of the process on the other end of
+// the unix socket using SO_PEERCRED and rejects it if its uid does not
+// match the uid of the deamon process
+func verifyPeer(connection net.Conn) error {
+	unixConnection, ok := connection.(*net.UnixConn)
+	if !ok {
+		return errors.New("connection is not a unix socket connection")
+	}
+
+	rawConnection, err := unixConnection.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var credentials *unix.Ucred
+	var credentialsErr error
+	err = rawConnection.Control(func(fd uintptr) {
+		credentials, credentialsErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return err
+	}
+	if credentialsErr != nil {
+		return credentialsErr
+	}
+
+	if int(credentials.Uid) != os.Getuid() {
+		return fmt.Errorf("peer uid %d does not match deamon uid %d", credentials.Uid, os.Getuid())
+	}
+
+	return nil
+}
+
+// this function makes sure the runtime directory is owned by the current user
+func checkOwner(info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("runtime directory is owned by uid %d", stat.Uid)
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatusReport is a snapshot of the connection diagnostics
+// returned by the "status" command of deamon process
+type StatusReport struct {
+	Connected          bool              `json:"connected"`
+	ServerAddress      string            `json:"server_address"`
+	SessionID          string            `json:"session_id,omitempty"`
+	TLSVersion         string            `json:"tls_version,omitempty"`
+	CipherSuite        string            `json:"cipher_suite,omitempty"`
+	CertificateSubject string            `json:"certificate_subject,omitempty"`
+	CertificateExpiry  time.Time         `json:"certificate_expiry,omitzero"`
+	ConnectedSince     time.Time         `json:"connected_since,omitzero"`
+	LastPing           time.Time         `json:"last_ping,omitzero"`
+	RoundTripMillis    float64           `json:"round_trip_ms,omitempty"`
+	EventCounts        map[string]uint64 `json:"event_counts"`
+	ReconnectAttempts  uint64            `json:"reconnect_attempts"`
+	Queue              DispatchStats     `json:"queue"`
+	LastError          string            `json:"last_error,omitempty"`
+}
+
+// connectionStatus tracks the state of the connection to server
+// it is updated by the connection goroutines and read by the status command
+type connectionStatus struct {
+	mu     sync.Mutex
+	report StatusReport
+}
+
+var status = &connectionStatus{
+	report: StatusReport{
+		ServerAddress: addr,
+		EventCounts:   make(map[string]uint64),
+	},
+}
+
+// this function records the details of a freshly established TLS connection
+func (s *connectionStatus) setConnected(state tls.ConnectionState, certificate tls.Certificate, sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.Connected = true
+	s.report.SessionID = sessionID
+	s.report.TLSVersion = tls.VersionName(state.Version)
+	s.report.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	s.report.ConnectedSince = time.Now()
+	s.report.LastError = ""
+	if len(certificate.Certificate) > 0 {
+		if leaf, err := x509.ParseCertificate(certificate.Certificate[0]); err == nil {
+			s.report.CertificateSubject = leaf.Subject.String()
+			s.report.CertificateExpiry = leaf.NotAfter
+		}
+	}
+}
+
+func (s *connectionStatus) setDisconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.Connected = false
+	s.report.SessionID = ""
+	s.report.ConnectedSince = time.Time{}
+}
+
+func (s *connectionStatus) setError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.LastError = err.Error()
+}
+
+func (s *connectionStatus) recordPing() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.LastPing = time.Now()
+}
+
+func (s *connectionStatus) recordRoundTrip(roundTrip time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.RoundTripMillis = float64(roundTrip.Microseconds()) / 1000
+}
+
+func (s *connectionStatus) recordEvent(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.EventCounts[name]++
+}
+
+func (s *connectionStatus) recordReconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.ReconnectAttempts++
+}
+
+// this function returns a copy of the report which is safe to use without the lock
+func (s *connectionStatus) snapshot() StatusReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := s.report
+	if eventQueue != nil {
+		report.Queue = eventQueue.snapshot()
+	}
+	report.EventCounts = make(map[string]uint64, len(s.report.EventCounts))
+	for name, count := range s.report.EventCounts {
+		report.EventCounts[name] = count
+	}
+
+	return report
+}
+
+// String renders the report as plain text
+// the first line stays "connected" or "disconnected" for older clients
+func (r StatusReport) String() string {
+	var builder strings.Builder
+	if r.Connected {
+		builder.WriteString("connected\n")
+	} else {
+		builder.WriteString("disconnected\n")
+	}
+
+	fmt.Fprintf(&builder, "Server:             %s\n", r.ServerAddress)
+	if len(r.SessionID) > 0 {
+		fmt.Fprintf(&builder, "Session:            %s\n", r.SessionID)
+	}
+	if len(r.TLSVersion) > 0 {
+		fmt.Fprintf(&builder, "TLS:                %s, %s\n", r.TLSVersion, r.CipherSuite)
+	}
+	if len(r.CertificateSubject) > 0 {
+		fmt.Fprintf(&builder, "Client certificate: %s (expires %s)\n", r.CertificateSubject, r.CertificateExpiry.Format(time.RFC1123))
+	}
+	if !r.ConnectedSince.IsZero() {
+		fmt.Fprintf(&builder, "Connected since:    %s (%s)\n", r.ConnectedSince.Format(time.RFC1123), time.Since(r.ConnectedSince).Round(time.Second))
+	}
+	if !r.LastPing.IsZero() {
+		fmt.Fprintf(&builder, "Last ping:          %s ago\n", time.Since(r.LastPing).Round(time.Second))
+	}
+	if r.RoundTripMillis > 0 {
+		fmt.Fprintf(&builder, "Round trip time:    %.1f ms\n", r.RoundTripMillis)
+	}
+	fmt.Fprintf(&builder, "Reconnect attempts: %d\n", r.ReconnectAttempts)
+
+	// printing event counts in a stable order
+	names := make([]string, 0, len(r.EventCounts))
+	for name := range r.EventCounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	counts := make([]string, 0, len(names))
+	for _, name := range names {
+		counts = append(counts, fmt.Sprintf("%s=%d", name, r.EventCounts[name]))
+	}
+	if len(counts) == 0 {
+		counts = append(counts, "none")
+	}
+	fmt.Fprintf(&builder, "Events:             %s\n", strings.Join(counts, " "))
+	fmt.Fprintf(&builder, "Event queue:        depth %d/%d (max %d), handled %d, coalesced %d, dropped %d\n",
+		r.Queue.QueueDepth, r.Queue.Capacity, r.Queue.MaxQueueDepth, r.Queue.Handled, r.Queue.Coalesced, r.Queue.Dropped)
+
+	if len(r.LastError) > 0 {
+		fmt.Fprintf(&builder, "Last error:         %s\n", r.LastError)
+	}
+
+	return builder.String()
+}
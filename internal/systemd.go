@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+)
+
+// first file descriptor passed by systemd for socket activation
+const listenFdsStart = 3
+
+// this function sends a state update like "READY=1" to systemd
+// it does nothing when the deamon was not started by systemd
+func sdNotify(state string) error {
+	socketAddress := os.Getenv("NOTIFY_SOCKET")
+	if len(socketAddress) == 0 {
+		return nil
+	}
+
+	// a leading '@' refers to a socket in the abstract namespace
+	if socketAddress[0] == '@' {
+		socketAddress = "\x00" + socketAddress[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketAddress, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// this function reports whether systemd expects watchdog pings from this process
+func watchdogEnabled() bool {
+	if len(os.Getenv("WATCHDOG_USEC")) == 0 {
+		return false
+	}
+
+	watchdogPid := os.Getenv("WATCHDOG_PID")
+	return len(watchdogPid) == 0 || watchdogPid == strconv.Itoa(os.Getpid())
+}
+
+// this function tells systemd that the deamon is still alive
+// it is called every time the server pings us so that a stuck connection
+// is restarted by systemd
+func notifyWatchdog() {
+	if !watchdogEnabled() {
+		return
+	}
+
+	if err := sdNotify("WATCHDOG=1"); err != nil {
+		log.Printf("Error sending watchdog notification: %v", err)
+	}
+}
+
+// this function returns the listener passed by systemd when cli.sock is
+// socket activated, or nil when the deamon has to create the socket itself
+func activationListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	listenFds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || listenFds < 1 {
+		return nil, nil
+	}
+
+	// unsetting the variables so that child processes do not inherit them
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(uintptr(listenFdsStart), socketFileName)
+	defer file.Close()
+
+	return net.FileListener(file)
+}
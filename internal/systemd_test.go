@@ -0,0 +1,103 @@
+//go:build linux
+
+package internal
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// this function binds a unixgram socket in a temp dir and points NOTIFY_SOCKET at it
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("error binding notify socket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+
+	return conn
+}
+
+// this function reads the next datagram sent to the notify socket
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	buffer := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	read, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatalf("error reading notification: %v", err)
+	}
+	return string(buffer[:read])
+}
+
+func TestSdNotifyReady(t *testing.T) {
+	conn := listenNotifySocket(t)
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("sdNotify returned error: %v", err)
+	}
+	if got := readNotification(t, conn); got != "READY=1" {
+		t.Errorf("got notification %q, want READY=1", got)
+	}
+}
+
+func TestSdNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("sdNotify without NOTIFY_SOCKET returned error: %v", err)
+	}
+}
+
+func TestNotifyWatchdog(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	notifyWatchdog()
+	if got := readNotification(t, conn); got != "WATCHDOG=1" {
+		t.Errorf("got notification %q, want WATCHDOG=1", got)
+	}
+}
+
+func TestWatchdogEnabled(t *testing.T) {
+	tests := []struct {
+		name  string
+		usec  string
+		pid   string
+		wants bool
+	}{
+		{name: "not configured", usec: "", pid: "", wants: false},
+		{name: "any process", usec: "30000000", pid: "", wants: true},
+		{name: "this process", usec: "30000000", pid: strconv.Itoa(os.Getpid()), wants: true},
+		{name: "other process", usec: "30000000", pid: strconv.Itoa(os.Getpid() + 1), wants: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", test.usec)
+			t.Setenv("WATCHDOG_PID", test.pid)
+			if got := watchdogEnabled(); got != test.wants {
+				t.Errorf("watchdogEnabled() = %v, want %v", got, test.wants)
+			}
+		})
+	}
+}
+
+func TestActivationListenerForOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	listener, err := activationListener()
+	if err != nil || listener != nil {
+		t.Errorf("activationListener() = %v, %v, want no listener for another process", listener, err)
+	}
+}
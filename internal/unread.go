@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+const unreadFileName = "unread.json"
+
+// MarkAllRead is the key which clears the unread count of every conversation
+const MarkAllRead = "all"
+
+// UnreadConversation is the unread state of a conversation, keyed by the
+// receiver id or group id in the unread file
+type UnreadConversation struct {
+	Name          string    `json:"name"`
+	IsGroup       bool      `json:"is_group,omitempty"`
+	Count         int       `json:"count"`
+	LastMessageAt time.Time `json:"last_message_at,omitzero"`
+
+	// messages created before this were already counted by the login summary
+	Since time.Time `json:"since,omitzero"`
+}
+
+// UnreadSummary is the number of new messages of a conversation reported on login
+type UnreadSummary struct {
+	Key     string `json:"key"`
+	Name    string `json:"name"`
+	IsGroup bool   `json:"is_group,omitempty"`
+	Count   int    `json:"count"`
+}
+
+// LoadUnread reads the unread counts kept by the deamon
+func LoadUnread() (map[string]UnreadConversation, error) {
+	counts := make(map[string]UnreadConversation)
+	if err := utility.ReadJSONFile(unreadFileName, &counts); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return counts, fmt.Errorf("error reading %s: %w", unreadFileName, err)
+	}
+
+	return counts, nil
+}
+
+// UnreadTotal returns the number of unread messages over all conversations
+func UnreadTotal(counts map[string]UnreadConversation) int {
+	total := 0
+	for _, conversation := range counts {
+		total += conversation.Count
+	}
+
+	return total
+}
+
+// unread counts are kept in memory by the deamon and written in batches
+// while the deamon runs every update goes through it, the cli only writes
+// the file itself when the deamon is not running
+var unread = newBatchedFile(unreadFileName, LoadUnread)
+
+// MarkRead clears the unread count of a conversation or of all of them with MarkAllRead
+// the file is written right away so that the cli sees the change
+func MarkRead(key string) error {
+	err := unread.update(func(counts map[string]UnreadConversation) {
+		for conversationKey, conversation := range counts {
+			if key == MarkAllRead || key == conversationKey {
+				conversation.Count = 0
+				counts[conversationKey] = conversation
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return unread.save()
+}
+
+// ApplyUnreadSummary replaces the unread counts of the conversations in the login summary
+// the deamon does not count the messages of the summary again when they are replayed
+// the file is written right away so that the cli sees the change
+func ApplyUnreadSummary(summary []UnreadSummary) error {
+	now := time.Now().UTC()
+	err := unread.update(func(counts map[string]UnreadConversation) {
+		for _, entry := range summary {
+			conversation := counts[entry.Key]
+			conversation.Name = entry.Name
+			conversation.IsGroup = entry.IsGroup
+			conversation.Count = entry.Count
+			conversation.Since = now
+			counts[entry.Key] = conversation
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return unread.save()
+}
+
+// this function counts a new message of another user as unread
+func countUnread(event events.Event, message *events.Message) error {
+	self, _ := currentUser()
+	if self.UserID != uuid.Nil && message.SenderID == self.UserID {
+		return nil
+	}
+
+	key := message.SenderID.String()
+	name := message.SenderUsername
+	isGroup := message.GroupID != uuid.Nil
+	if isGroup {
+		key = message.GroupID.String()
+		name = cachedGroupName(message.GroupID)
+	}
+	createdAt := eventTime(event.Payload)
+
+	return unread.update(func(counts map[string]UnreadConversation) {
+		conversation := counts[key]
+		if !createdAt.IsZero() && createdAt.Before(conversation.Since) {
+			return
+		}
+		if len(name) > 0 {
+			conversation.Name = name
+		}
+		conversation.IsGroup = isGroup
+		conversation.Count++
+		if createdAt.After(conversation.LastMessageAt) {
+			conversation.LastMessageAt = createdAt
+		}
+		counts[key] = conversation
+	})
+}
+
+// this function subscribes the unread counts to new messages
+// replayed messages were counted when they were first received
+func registerUnread(bus *events.Bus) {
+	events.On(bus, events.NEW_MESSAGE, events.SkipReplay(countUnread))
+}
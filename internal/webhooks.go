@@ -0,0 +1,354 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/harshvardha/TerTerChatCLI/internal/events"
+	"github.com/harshvardha/TerTerChatCLI/utility"
+)
+
+// deliveries which have not reached their webhook yet survive restarts in this file
+const webhookQueueFile = "webhook_queue.json"
+
+// webhookBody is the json posted to a webhook
+type webhookBody struct {
+	Event      string          `json:"event"`
+	ID         string          `json:"id,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
+	ReceivedAt time.Time       `json:"received_at"`
+}
+
+// webhookDelivery is an event waiting to be posted to one webhook
+type webhookDelivery struct {
+	ID          uuid.UUID       `json:"id"`
+	Webhook     string          `json:"webhook"`
+	Event       string          `json:"event"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// errPermanent marks a delivery the webhook refused for good, it is not retried
+var errPermanent = errors.New("webhook rejected delivery")
+
+// webhookSink posts events to the configured webhooks one delivery at a time
+// failed deliveries are retried with exponential backoff
+type webhookSink struct {
+	config    WebhooksConfig
+	endpoints map[string]WebhookConfig
+	client    *http.Client
+
+	mu    sync.Mutex
+	queue []webhookDelivery
+
+	// set when the queue changed since it was last written, run writes it
+	// once per batch of events and deliveries rather than on every change
+	dirty bool
+
+	wake chan struct{}
+	quit chan struct{}
+	done chan struct{}
+}
+
+// webhooks is replaced with the configured sink when the deamon starts
+var webhooks = newWebhookSink(defaultConfig().Webhooks)
+
+func newWebhookSink(config WebhooksConfig) *webhookSink {
+	endpoints := make(map[string]WebhookConfig)
+	for _, webhook := range config.Endpoints {
+		endpoints[webhook.Name] = webhook
+	}
+
+	return &webhookSink{
+		config:    config,
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: time.Duration(config.Timeout)},
+		wake:      make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// this function loads the deliveries left by a previous run and starts delivering
+func (s *webhookSink) start() {
+	queue := make([]webhookDelivery, 0)
+	if err := utility.ReadJSONFile(webhookQueueFile, &queue); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error reading webhook queue, starting with an empty one: %v", err)
+	}
+
+	// deliveries of webhooks removed from config.json are dropped
+	s.mu.Lock()
+	for _, delivery := range queue {
+		if _, ok := s.endpoints[delivery.Webhook]; ok {
+			s.queue = append(s.queue, delivery)
+		}
+	}
+	if len(s.queue) != len(queue) {
+		s.dirty = true
+	}
+	s.mu.Unlock()
+
+	go s.run()
+}
+
+// this function stops delivering, pending deliveries stay in the queue file
+func (s *webhookSink) stop() {
+	close(s.quit)
+	<-s.done
+}
+
+// this function queues the event for every webhook interested in it
+func (s *webhookSink) enqueue(event events.Event) {
+	if len(s.endpoints) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(webhookBody{
+		Event:      event.Name,
+		ID:         event.ID,
+		Payload:    event.Payload,
+		ReceivedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Error encoding %s event for webhooks: %v", event.Name, err)
+		return
+	}
+
+	s.mu.Lock()
+	queued := 0
+	for _, webhook := range s.config.Endpoints {
+		if len(webhook.Events) > 0 && !slices.Contains(webhook.Events, event.Name) {
+			continue
+		}
+		queued++
+		s.queue = append(s.queue, webhookDelivery{
+			ID:          uuid.New(),
+			Webhook:     webhook.Name,
+			Event:       event.Name,
+			Body:        body,
+			NextAttempt: time.Now(),
+		})
+	}
+	if overflow := len(s.queue) - s.config.MaxQueued; overflow > 0 {
+		log.Printf("Webhook queue is full, dropping %d oldest deliveries", overflow)
+		s.queue = slices.Delete(s.queue, 0, overflow)
+	}
+	if queued > 0 {
+		s.dirty = true
+	}
+	s.mu.Unlock()
+	if queued == 0 {
+		return
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// this function delivers queued events as they become due until the sink is stopped
+func (s *webhookSink) run() {
+	defer close(s.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.quit:
+			s.persist()
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+		// writing the events queued since the last write before delivering them
+		s.persist()
+
+		// delivering everything due before sleeping until the next attempt
+		for {
+			delivery, ok := s.due()
+			if !ok {
+				break
+			}
+			err := s.deliver(delivery)
+			s.finish(delivery, err)
+
+			select {
+			case <-s.quit:
+				s.persist()
+				return
+			default:
+			}
+		}
+		s.persist()
+
+		timer.Reset(s.untilNextAttempt())
+	}
+}
+
+// this function returns the first delivery whose attempt is due
+func (s *webhookSink) due() (webhookDelivery, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, delivery := range s.queue {
+		if !delivery.NextAttempt.After(now) {
+			return delivery, true
+		}
+	}
+
+	return webhookDelivery{}, false
+}
+
+// this function returns how long until the next delivery is due
+func (s *webhookSink) untilNextAttempt() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wait := time.Hour
+	for _, delivery := range s.queue {
+		if until := time.Until(delivery.NextAttempt); until < wait {
+			wait = max(until, 0)
+		}
+	}
+
+	return wait
+}
+
+// this function posts a delivery to its webhook
+func (s *webhookSink) deliver(delivery webhookDelivery) error {
+	webhook, ok := s.endpoints[delivery.Webhook]
+	if !ok {
+		return fmt.Errorf("%w: webhook %s is not configured", errPermanent, delivery.Webhook)
+	}
+
+	request, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "TerTerChatCLI/"+ClientVersion)
+	request.Header.Set("X-TerTer-Event", delivery.Event)
+	request.Header.Set("X-TerTer-Delivery", delivery.ID.String())
+	request.Header.Set("X-TerTer-Timestamp", timestamp)
+	if len(webhook.Secret) > 0 {
+		request.Header.Set("X-TerTer-Signature", "sha256="+signWebhook(webhook.Secret, timestamp, delivery.Body))
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return nil
+	case response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	default:
+		return fmt.Errorf("%w with %s", errPermanent, response.Status)
+	}
+}
+
+// this function removes a finished delivery or schedules its next attempt
+func (s *webhookSink) finish(delivery webhookDelivery, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := slices.IndexFunc(s.queue, func(queued webhookDelivery) bool { return queued.ID == delivery.ID })
+	if index == -1 {
+		return
+	}
+
+	queued := &s.queue[index]
+	queued.Attempts++
+	switch {
+	case err == nil:
+		s.queue = slices.Delete(s.queue, index, index+1)
+	case errors.Is(err, errPermanent) || queued.Attempts >= s.config.MaxAttempts:
+		log.Printf("Giving up %s delivery %s to webhook %s after %d attempts: %v", queued.Event, queued.ID, queued.Webhook, queued.Attempts, err)
+		s.queue = slices.Delete(s.queue, index, index+1)
+	default:
+		backoff := s.backoff(queued.Attempts)
+		log.Printf("Delivery %s to webhook %s failed, retrying in %s: %v", queued.ID, queued.Webhook, backoff.Round(time.Second), err)
+		queued.NextAttempt = time.Now().Add(backoff)
+		queued.LastError = err.Error()
+	}
+	s.dirty = true
+}
+
+// this function returns the delay before the next attempt of a delivery
+// the delay doubles with every attempt and is jittered so that webhooks
+// coming back up are not hit by every retry at once
+func (s *webhookSink) backoff(attempts int) time.Duration {
+	backoff := time.Duration(s.config.RetryMin)
+	for range attempts - 1 {
+		backoff *= 2
+		if backoff >= time.Duration(s.config.RetryMax) {
+			backoff = time.Duration(s.config.RetryMax)
+			break
+		}
+	}
+
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// this function writes the queue to its file when it changed since the last write
+// the file is written from a copy so that enqueue is not held up by the write
+func (s *webhookSink) persist() {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return
+	}
+	queue := slices.Clone(s.queue)
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err := utility.WriteJSONFile(webhookQueueFile, queue); err != nil {
+		log.Printf("Error writing webhook queue: %v", err)
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+}
+
+// this function signs the timestamp and body of a delivery with the secret of its webhook
+// receivers recompute hex(hmac_sha256(secret, timestamp + "." + body)) to verify it
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// middleware which hands every event received live to the webhook sink before its handlers
+func deliverWebhooks(next events.Handler) events.Handler {
+	return func(event events.Event) error {
+		if !event.Replay {
+			webhooks.enqueue(event)
+		}
+		return next(event)
+	}
+}
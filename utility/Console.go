@@ -21,3 +21,17 @@ func ClearConsole() {
 		fmt.Print("\033[H\033[2J")
 	}
 }
+
+// FormatSize formats a number of bytes for humans, e.g. 1.5 MB
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exponent := float64(size)/unit, 0
+	for value >= unit && exponent < 3 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGT"[exponent])
+}
@@ -0,0 +1,43 @@
+package utility
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// ReadJSONFile unmarshals the content of a json file into value
+func ReadJSONFile(path string, value any) error {
+	jsonData, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(jsonData, value)
+}
+
+// WriteJSONFile marshals value into a json file
+// the data is written to a temporary file first and then renamed so that
+// a reader never sees a partially written file
+func WriteJSONFile(path string, value any) error {
+	jsonData, err := json.MarshalIndent(value, "", " ")
+	if err != nil {
+		return err
+	}
+
+	temporaryFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+
+	if _, err = temporaryFile.Write(jsonData); err != nil {
+		temporaryFile.Close()
+		return err
+	}
+	if err = temporaryFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryFile.Name(), path)
+}
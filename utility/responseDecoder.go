@@ -50,6 +50,12 @@ type GroupConversation struct {
 	GroupName string
 }
 
+// member of a group as cached in <groupID>_members.json
+type GroupMember struct {
+	ID       uuid.UUID
+	Username string
+}
+
 type Message struct {
 	ID          uuid.UUID
 	Description string
@@ -61,6 +67,26 @@ type Message struct {
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 	Read        bool
+	ParentID    uuid.NullUUID
+	Attachment  *Attachment
+}
+
+// file attached to a message, Checksum is the hex encoded sha256 of its content
+type Attachment struct {
+	ID       uuid.UUID
+	FileName string
+	Size     int64
+	MimeType string
+	Checksum string
+}
+
+// response body decoder struct for message --attach upload requests
+// AttachmentID is only set once the upload is completed
+type UploadResponse struct {
+	UploadID     uuid.UUID `json:"upload_id"`
+	Offset       int64     `json:"offset"`
+	AttachmentID uuid.UUID `json:"attachment_id"`
+	AccessToken  string    `json:"access_token"`
 }
 
 // response body decoder struct for user --login command
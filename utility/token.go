@@ -0,0 +1,53 @@
+package utility
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// claims of the jwt issued by server on login which are useful to the cli
+type TokenClaims struct {
+	UserID   uuid.UUID
+	Username string
+}
+
+// ParseTokenClaims reads the claims of the jwt without verifying its signature
+// the token is only used locally to know who the current user is, the server
+// still verifies it on every request
+func ParseTokenClaims(token string) (TokenClaims, error) {
+	claims := TokenClaims{}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, err
+	}
+
+	rawClaims := struct {
+		Subject  string `json:"sub"`
+		ID       string `json:"id"`
+		UserID   string `json:"user_id"`
+		Username string `json:"username"`
+	}{}
+	if err = json.Unmarshal(payload, &rawClaims); err != nil {
+		return claims, err
+	}
+
+	// different versions of server put the user id in different claims
+	for _, id := range []string{rawClaims.UserID, rawClaims.ID, rawClaims.Subject} {
+		if parsedID, err := uuid.Parse(id); err == nil {
+			claims.UserID = parsedID
+			break
+		}
+	}
+	claims.Username = rawClaims.Username
+
+	return claims, nil
+}